@@ -6,6 +6,8 @@ import (
 	"go/constant"
 	"go/token"
 	"go/types"
+	"net/textproto"
+	"reflect"
 	"strconv"
 	"strings"
 	"sync"
@@ -41,11 +43,39 @@ var Analyzer = &analysis.Analyzer{
 var checkTypes bool
 var clientPrefix string
 var serverPrefix string
+var allowBodies bool
+var strictHeaders bool
 
 func init() {
 	Analyzer.Flags.BoolVar(&checkTypes, "types", true, "check that request/response types match in client and server")
 	Analyzer.Flags.StringVar(&clientPrefix, "cprefix", "", "client endpoint URL prefix to trim")
 	Analyzer.Flags.StringVar(&serverPrefix, "sprefix", "", "server endpoint URL prefix to trim")
+	Analyzer.Flags.BoolVar(&allowBodies, "bodies", false, "allow PUT routes to write a response object, and DELETE routes to read a request object and write a response object")
+	Analyzer.Flags.BoolVar(&strictHeaders, "headers", false, "report headers sent or read by the client that the server does not decode or set with DecodeHeader and SetHeader")
+}
+
+// mayReadRequest reports whether routes with the specified method may read a
+// request object.
+func mayReadRequest(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS":
+		return false
+	case "DELETE":
+		return allowBodies
+	}
+	return true
+}
+
+// mayWriteResponse reports whether routes with the specified method may write
+// a response object.
+func mayWriteResponse(method string) bool {
+	switch method {
+	case "HEAD", "OPTIONS":
+		return false
+	case "PUT", "DELETE":
+		return allowBodies
+	}
+	return true
 }
 
 func isPtr(t types.Type) bool {
@@ -53,6 +83,52 @@ func isPtr(t types.Type) bool {
 	return ok
 }
 
+// japeFunc returns the name of the package-level jape function called by expr,
+// along with the identifier used to call it, or the empty string if expr is not
+// such a call.
+func japeFunc(expr ast.Expr, info *types.Info) (string, *ast.Ident) {
+	call, ok := expr.(*ast.CallExpr)
+	if !ok {
+		return "", nil
+	}
+	fun := call.Fun
+	switch f := fun.(type) {
+	case *ast.IndexExpr:
+		fun = f.X // explicit type argument, e.g. jape.Stream[T]
+	case *ast.IndexListExpr:
+		fun = f.X
+	}
+	var id *ast.Ident
+	switch f := fun.(type) {
+	case *ast.Ident:
+		id = f
+	case *ast.SelectorExpr:
+		id = f.Sel
+	default:
+		return "", nil
+	}
+	if fn, ok := info.Uses[id].(*types.Func); ok && fn.Pkg() != nil && fn.Pkg().Path() == "go.sia.tech/jape" && fn.Signature().Recv() == nil {
+		return fn.Name(), id
+	}
+	return "", nil
+}
+
+// clientFuncs are the package-level jape functions that make client requests.
+var clientFuncs = map[string]bool{
+	"Stream":     true,
+	"POSTStream": true,
+	"Subscribe":  true,
+	"Watch":      true,
+	"Paginate":   true,
+
+	"DialWebSocket": true,
+
+	"GET":   true,
+	"POST":  true,
+	"PUT":   true,
+	"PATCH": true,
+}
+
 func evalConstString(expr ast.Expr, info *types.Info) string {
 	switch v := expr.(type) {
 	case *ast.BasicLit:
@@ -86,6 +162,65 @@ func evalConstString(expr ast.Expr, info *types.Info) string {
 	}
 }
 
+// isPageOf reports whether t is a []elem or a jape.Page[elem].
+func isPageOf(t, elem types.Type) bool {
+	if s, ok := t.Underlying().(*types.Slice); ok {
+		return types.Identical(s.Elem(), elem)
+	} else if n, ok := t.(*types.Named); ok && n.Obj().Pkg() != nil && n.Obj().Pkg().Path() == "go.sia.tech/jape" && n.Obj().Name() == "Page" {
+		return types.Identical(n.TypeArgs().At(0), elem)
+	}
+	return false
+}
+
+func hasMethod(t types.Type, name string) bool {
+	obj, _, _ := types.LookupFieldOrMethod(types.NewPointer(t), true, nil, name)
+	_, ok := obj.(*types.Func)
+	return ok
+}
+
+// unmarshalable returns a non-empty reason if values of type t can never be
+// marshalled by encoding/json.
+func unmarshalable(t types.Type, seen map[types.Type]bool) string {
+	if seen[t] {
+		return ""
+	}
+	seen[t] = true
+	if hasMethod(t, "MarshalJSON") || hasMethod(t, "MarshalText") {
+		return ""
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		switch u.Kind() {
+		case types.Complex64, types.Complex128, types.UnsafePointer:
+			return fmt.Sprintf("%v is not supported", t)
+		}
+	case *types.Chan, *types.Signature:
+		return fmt.Sprintf("%v is not supported", t)
+	case *types.Pointer:
+		return unmarshalable(u.Elem(), seen)
+	case *types.Slice:
+		return unmarshalable(u.Elem(), seen)
+	case *types.Array:
+		return unmarshalable(u.Elem(), seen)
+	case *types.Map:
+		key, ok := u.Key().Underlying().(*types.Basic)
+		if (!ok || key.Info()&(types.IsString|types.IsInteger) == 0) && !hasMethod(u.Key(), "MarshalText") {
+			return fmt.Sprintf("map key type %v is not supported", u.Key())
+		}
+		return unmarshalable(u.Elem(), seen)
+	case *types.Struct:
+		for i := 0; i < u.NumFields(); i++ {
+			f := u.Field(i)
+			if (!f.Exported() && !f.Embedded()) || reflect.StructTag(u.Tag(i)).Get("json") == "-" {
+				continue
+			} else if reason := unmarshalable(f.Type(), seen); reason != "" {
+				return fmt.Sprintf("field %v: %v", f.Name(), reason)
+			}
+		}
+	}
+	return ""
+}
+
 type serverParam struct {
 	name string
 	typ  types.Type
@@ -95,10 +230,20 @@ type serverRoute struct {
 	method string
 	path   string
 
-	pathParams  []serverParam
-	queryParams map[string]types.Type
-	request     types.Type
-	response    types.Type
+	pathParams     []serverParam
+	queryParams    map[string]types.Type
+	requiredParams map[string]bool
+	flagParams     map[string]bool
+	reqHeaders     map[string]types.Type
+	respHeaders    map[string]types.Type
+	statuses       map[int]bool
+	dynamicStatus  bool
+	request        types.Type
+	response       types.Type
+	stream         string // the Context method used to stream the response, if any
+	streamRequest  string
+	blocking       bool
+	paginated      bool
 
 	seen bool
 }
@@ -144,9 +289,14 @@ func parseServerRoute(kv *ast.KeyValueExpr, pass *analysis.Pass) (*serverRoute,
 	}
 
 	r := &serverRoute{
-		method:      methodPath[0],
-		path:        strings.TrimPrefix(methodPath[1], serverPrefix),
-		queryParams: make(map[string]types.Type),
+		method:         methodPath[0],
+		path:           strings.TrimPrefix(methodPath[1], serverPrefix),
+		queryParams:    make(map[string]types.Type),
+		requiredParams: make(map[string]bool),
+		flagParams:     make(map[string]bool),
+		reqHeaders:     make(map[string]types.Type),
+		respHeaders:    make(map[string]types.Type),
+		statuses:       make(map[int]bool),
 	}
 	// parse path params
 	for _, param := range strings.Split(r.path, "/") {
@@ -176,9 +326,29 @@ func parseServerRoute(kv *ast.KeyValueExpr, pass *analysis.Pass) (*serverRoute,
 	ast.Inspect(funcBody, func(n ast.Node) bool {
 		if call, ok := n.(*ast.CallExpr); !ok {
 			return true
+		} else if name, id := japeFunc(call, pass.TypesInfo); name == "UpgradeWebSocket" {
+			// UpgradeWebSocket[R, W any](c Context)
+			typeArgs := pass.TypesInfo.Instances[id].TypeArgs
+			r.request = types.NewPointer(typeArgs.At(0))
+			r.response = typeArgs.At(1)
+			r.stream = "UpgradeWebSocket"
+			return true
 		} else if sel, ok := call.Fun.(*ast.SelectorExpr); !ok {
 			return true
-		} else if typ := typeof(sel.X); typ == nil || typ.String() != "go.sia.tech/jape.Context" {
+		} else if typ := typeof(sel.X); typ != nil && typ.String() == "*go.sia.tech/jape.EventStream" {
+			if sel.Sel.Name == "Send" {
+				typ := typeof(call.Args[2])
+				if checkTypes && r.response != nil && !types.Identical(typ, r.response) {
+					pass.Report(analysis.Diagnostic{
+						Pos:     call.Args[2].Pos(),
+						Message: fmt.Sprintf("Send called on %v, but was previously called on %v", typ, r.response),
+					})
+					return false
+				}
+				r.response = typ
+			}
+			return true
+		} else if typ == nil || typ.String() != "go.sia.tech/jape.Context" {
 			return true
 		} else {
 			switch sel.Sel.Name {
@@ -225,14 +395,17 @@ func parseServerRoute(kv *ast.KeyValueExpr, pass *analysis.Pass) (*serverRoute,
 						})
 						return false
 					}
-					if r.response != types.Typ[types.UntypedNil] {
+					if !allowBodies && r.response != types.Typ[types.UntypedNil] {
 						pass.Report(analysis.Diagnostic{
 							Pos:     call.Args[1].Pos(),
 							Message: fmt.Sprintf("%v routes should not write a response object", r.method),
 						})
 						return false
 					}
-				case "DELETE":
+				case "DELETE", "HEAD", "OPTIONS":
+					if r.method == "DELETE" && allowBodies {
+						break
+					}
 					if r.request != types.Typ[types.UntypedNil] {
 						pass.Report(analysis.Diagnostic{
 							Pos:     call.Args[0].Pos(),
@@ -249,8 +422,8 @@ func parseServerRoute(kv *ast.KeyValueExpr, pass *analysis.Pass) (*serverRoute,
 					}
 				}
 
-			case "Decode":
-				if r.method == "GET" || r.method == "DELETE" {
+			case "Decode", "DecodeStream", "DecodeStreamLimit":
+				if !mayReadRequest(r.method) {
 					pass.Report(analysis.Diagnostic{
 						Pos:     call.Pos(),
 						Message: fmt.Sprintf("%v routes should not read a request object", r.method),
@@ -273,26 +446,86 @@ func parseServerRoute(kv *ast.KeyValueExpr, pass *analysis.Pass) (*serverRoute,
 					return false
 				}
 				r.request = typ
+				if sel.Sel.Name != "Decode" {
+					r.streamRequest = "DecodeStream"
+				}
 
-			case "Encode":
-				if r.method == "PUT" || r.method == "DELETE" {
+			case "ReadBody":
+				if !mayReadRequest(r.method) {
+					pass.Report(analysis.Diagnostic{
+						Pos:     call.Pos(),
+						Message: fmt.Sprintf("%v routes should not read a request object", r.method),
+					})
+					return false
+				}
+				r.streamRequest = "ReadBody"
+
+			case "WriteBody":
+				r.stream = "WriteBody"
+				r.statuses[200] = true
+
+			case "Encode", "EncodeStatus", "EncodePage", "Created", "Accepted":
+				arg := call.Args[0]
+				if sel.Sel.Name == "EncodeStatus" || sel.Sel.Name == "Created" {
+					arg = call.Args[1]
+				}
+				typ := typeof(arg)
+				switch sel.Sel.Name {
+				case "Encode":
+					if typ == types.Typ[types.UntypedNil] {
+						r.statuses[204] = true
+					} else {
+						r.statuses[200] = true
+					}
+				case "EncodeStatus":
+					if tv := pass.TypesInfo.Types[call.Args[0]]; tv.Value != nil {
+						status, _ := constant.Int64Val(tv.Value)
+						if status < 200 || status >= 300 {
+							pass.Report(analysis.Diagnostic{
+								Pos:     call.Args[0].Pos(),
+								Message: fmt.Sprintf("EncodeStatus called with non-success status code %v", status),
+							})
+							return false
+						}
+						r.statuses[int(status)] = true
+					} else {
+						r.dynamicStatus = true
+					}
+				case "EncodePage":
+					r.statuses[200] = true
+				case "Created":
+					r.statuses[201] = true
+					r.respHeaders["Location"] = types.Typ[types.String]
+				case "Accepted":
+					r.statuses[202] = true
+				}
+				if !mayWriteResponse(r.method) && (sel.Sel.Name == "Encode" || typ != types.Typ[types.UntypedNil]) {
 					pass.Report(analysis.Diagnostic{
 						Pos:     call.Pos(),
 						Message: fmt.Sprintf("%v routes should not write a response object", r.method),
 					})
 					return false
+				} else if typ == types.Typ[types.UntypedNil] && sel.Sel.Name != "Encode" {
+					return true // status only
+				}
+				if typ != nil && typ != types.Typ[types.UntypedNil] {
+					if reason := unmarshalable(typ, make(map[types.Type]bool)); reason != "" {
+						pass.Report(analysis.Diagnostic{
+							Pos:     arg.Pos(),
+							Message: fmt.Sprintf("Response type %v cannot be marshalled as JSON: %v", typ, reason),
+						})
+					}
 				}
-				typ := typeof(call.Args[0])
 				if checkTypes && r.response != nil && !types.Identical(typ, r.response) {
 					pass.Report(analysis.Diagnostic{
-						Pos:     call.Args[0].Pos(),
-						Message: fmt.Sprintf("Encode called on %v, but was previously called on %v", typ, r.response),
+						Pos:     arg.Pos(),
+						Message: fmt.Sprintf("%v called on %v, but was previously called on %v", sel.Sel.Name, typ, r.response),
 					})
 					return false
 				}
 				r.response = typ
 
-			case "DecodeForm":
+			case "DecodeForm", "DecodeFormRequired", "DecodeFormDefault":
 				name := evalConstString(call.Args[0], pass.TypesInfo)
 				typ := typeof(call.Args[1])
 				if prev, ok := r.queryParams[name]; ok && checkTypes && !types.Identical(prev, typ) {
@@ -302,7 +535,114 @@ func parseServerRoute(kv *ast.KeyValueExpr, pass *analysis.Pass) (*serverRoute,
 					})
 					return false
 				}
+				if sel.Sel.Name == "DecodeFormDefault" && checkTypes && isPtr(typ) {
+					def := typeof(call.Args[2])
+					isNumeric := func(t types.Type) bool {
+						b, ok := t.Underlying().(*types.Basic)
+						return ok && b.Info()&types.IsNumeric != 0
+					}
+					if want := typ.(*types.Pointer).Elem(); def != nil && def != types.Typ[types.UntypedNil] && !types.AssignableTo(def, want) && !(isNumeric(def) && isNumeric(want)) {
+						pass.Report(analysis.Diagnostic{
+							Pos:     call.Args[2].Pos(),
+							Message: fmt.Sprintf("Default for form value %q has type %v, which is not assignable to %v", name, def, want),
+						})
+						return false
+					}
+				}
 				r.queryParams[name] = typ
+				if sel.Sel.Name == "DecodeFormRequired" {
+					r.requiredParams[name] = true
+				}
+
+			case "HasForm":
+				r.flagParams[evalConstString(call.Args[0], pass.TypesInfo)] = true
+
+			case "EncodeStream":
+				var typ types.Type
+				switch u := typeof(call.Args[0]).Underlying().(type) {
+				case *types.Chan:
+					typ = u.Elem()
+				case *types.Signature:
+					if u.Params().Len() == 1 {
+						if yield, ok := u.Params().At(0).Type().Underlying().(*types.Signature); ok && yield.Params().Len() == 1 {
+							typ = yield.Params().At(0).Type()
+						}
+					}
+				}
+				if typ == nil {
+					pass.Report(analysis.Diagnostic{
+						Pos:     call.Args[0].Pos(),
+						Message: "EncodeStream called on value that is neither an iter.Seq nor a channel",
+					})
+					return false
+				} else if checkTypes && r.response != nil && !types.Identical(typ, r.response) {
+					pass.Report(analysis.Diagnostic{
+						Pos:     call.Args[0].Pos(),
+						Message: fmt.Sprintf("EncodeStream called on %v, but was previously called on %v", typ, r.response),
+					})
+					return false
+				}
+				r.response = typ
+				r.stream = "EncodeStream"
+				r.statuses[200] = true
+
+			case "Events":
+				r.stream = "Events"
+				r.statuses[200] = true
+
+			case "StartJob":
+				typ := pass.TypesInfo.ObjectOf(sel.Sel).Pkg().Scope().Lookup("JobStatus").Type()
+				if checkTypes && r.response != nil && !types.Identical(typ, r.response) {
+					pass.Report(analysis.Diagnostic{
+						Pos:     call.Pos(),
+						Message: fmt.Sprintf("StartJob writes %v, but %v was previously written", typ, r.response),
+					})
+					return false
+				}
+				r.response = typ
+				r.statuses[202] = true
+
+			case "DecodePage":
+				r.paginated = true
+				r.queryParams["limit"] = types.NewPointer(types.Typ[types.Int])
+				r.queryParams["offset"] = types.NewPointer(types.Typ[types.Int])
+				r.queryParams["cursor"] = types.NewPointer(types.Typ[types.String])
+
+			case "WaitIndex":
+				r.blocking = true
+				r.queryParams["index"] = types.NewPointer(types.Typ[types.Uint64])
+				r.flagParams["wait"] = true
+				r.respHeaders["X-Index"] = types.Typ[types.Uint64]
+
+			case "DecodeHeader":
+				name := textproto.CanonicalMIMEHeaderKey(evalConstString(call.Args[0], pass.TypesInfo))
+				typ := typeof(call.Args[1])
+				if !isPtr(typ) {
+					pass.Report(analysis.Diagnostic{
+						Pos:     call.Args[1].Pos(),
+						Message: "DecodeHeader called on non-pointer value",
+					})
+					return false
+				} else if prev, ok := r.reqHeaders[name]; ok && checkTypes && !types.Identical(prev, typ) {
+					pass.Report(analysis.Diagnostic{
+						Pos:     call.Pos(),
+						Message: fmt.Sprintf("Header %q decoded as %v, but was previously decoded as %v", name, typ, prev),
+					})
+					return false
+				}
+				r.reqHeaders[name] = typ
+
+			case "SetHeader":
+				name := textproto.CanonicalMIMEHeaderKey(evalConstString(call.Args[0], pass.TypesInfo))
+				typ := typeof(call.Args[1])
+				if prev, ok := r.respHeaders[name]; ok && checkTypes && !types.Identical(prev, typ) {
+					pass.Report(analysis.Diagnostic{
+						Pos:     call.Pos(),
+						Message: fmt.Sprintf("Header %q set to %v, but was previously set to %v", name, typ, prev),
+					})
+					return false
+				}
+				r.respHeaders[name] = typ
 
 			case "DecodeParam":
 				name := evalConstString(call.Args[0], pass.TypesInfo)
@@ -368,13 +708,13 @@ func parseServerRoute(kv *ast.KeyValueExpr, pass *analysis.Pass) (*serverRoute,
 		r.response = types.Typ[types.UntypedNil]
 	}
 
-	if checkTypes && r.method == "GET" && r.response == types.Typ[types.UntypedNil] {
+	if checkTypes && r.method == "GET" && r.response == types.Typ[types.UntypedNil] && r.stream == "" {
 		pass.Report(analysis.Diagnostic{
 			Pos:     funcBody.Pos(),
 			Message: fmt.Sprintf("%v routes should write a response object", r.method),
 		})
 		return nil, false
-	} else if r.method == "PUT" && r.request == types.Typ[types.UntypedNil] {
+	} else if r.method == "PUT" && r.request == types.Typ[types.UntypedNil] && r.streamRequest == "" {
 		pass.Report(analysis.Diagnostic{
 			Pos:     funcBody.Pos(),
 			Message: fmt.Sprintf("%v routes should read a request object", r.method),
@@ -385,12 +725,55 @@ func parseServerRoute(kv *ast.KeyValueExpr, pass *analysis.Pass) (*serverRoute,
 	return r, true
 }
 
+// derivedRoute returns the server route that implicitly handles cr, if any.
+// The Mux answers HEAD requests to GET routes by running the GET handler
+// without sending the response body, and answers OPTIONS requests to any path
+// with the allowed methods.
+func derivedRoute(cr *clientRoute, derived map[string]*serverRoute) (*serverRoute, bool) {
+	key := cr.normalizedRoute()
+	if sr, ok := derived[key]; ok {
+		return sr, true
+	}
+	path := strings.TrimPrefix(key, cr.method)
+	var base *serverRoute
+	switch cr.method {
+	case "HEAD":
+		base = routes["GET"+path]
+	case "OPTIONS":
+		for _, method := range []string{"GET", "POST", "PUT", "DELETE", "PATCH", "HEAD"} {
+			if base = routes[method+path]; base != nil {
+				break
+			}
+		}
+	}
+	if base == nil {
+		return nil, false
+	}
+	sr := *base
+	sr.method = cr.method
+	sr.request = types.Typ[types.UntypedNil]
+	sr.response = types.Typ[types.UntypedNil]
+	sr.stream, sr.streamRequest = "", ""
+	sr.seen = false
+	if cr.method == "OPTIONS" {
+		sr.queryParams = make(map[string]types.Type)
+		sr.requiredParams = nil
+		sr.reqHeaders = make(map[string]types.Type)
+		sr.respHeaders = map[string]types.Type{"Allow": types.Typ[types.String]}
+		sr.statuses = map[int]bool{200: true}
+	}
+	derived[key] = &sr
+	return &sr, true
+}
+
 func checkSingleResponse(kv *ast.KeyValueExpr, pass *analysis.Pass) {
 	typeof := func(e ast.Expr) types.Type { return pass.TypesInfo.TypeOf(e) }
 
 	isWrite := func(n ast.Node) bool {
 		if call, ok := n.(*ast.CallExpr); !ok {
 			return false
+		} else if name, _ := japeFunc(call, pass.TypesInfo); name == "UpgradeWebSocket" {
+			return true
 		} else if sel, ok := call.Fun.(*ast.SelectorExpr); !ok {
 			return false
 		} else if typ := typeof(sel.X); typ == nil || typ.String() != "go.sia.tech/jape.Context" {
@@ -400,9 +783,25 @@ func checkSingleResponse(kv *ast.KeyValueExpr, pass *analysis.Pass) {
 			return m == "Error" ||
 				m == "Check" ||
 				m == "Decode" ||
+				m == "DecodeStream" ||
+				m == "DecodeStreamLimit" ||
 				m == "DecodeParam" ||
 				m == "DecodeForm" ||
-				m == "Encode"
+				m == "DecodeFormRequired" ||
+				m == "DecodeFormDefault" ||
+				m == "DecodeHeader" ||
+				m == "Encode" ||
+				m == "EncodeStream" ||
+				m == "Events" ||
+				m == "WaitIndex" ||
+				m == "StartJob" ||
+				m == "DecodePage" ||
+				m == "EncodePage" ||
+				m == "ReadBody" ||
+				m == "WriteBody" ||
+				m == "EncodeStatus" ||
+				m == "Created" ||
+				m == "Accepted"
 		}
 	}
 	containsWrite := func(n ast.Node) bool {
@@ -541,6 +940,23 @@ type clientRoute struct {
 	queryParams map[string]ast.Expr
 	request     ast.Expr
 	response    ast.Expr
+	reqHeaders  map[string]ast.Expr
+	respHeaders map[string]ast.Expr
+	status      ast.Expr
+
+	// for package-level helpers, the request and response types may be type
+	// arguments rather than expressions
+	reqType       types.Type
+	respType      types.Type
+	stream        string
+	streamRequest string
+	blocking      bool
+	paginated     bool
+
+	// query keys that the client sends with hard-coded values, and whether
+	// the query string may contain keys that cannot be determined statically
+	constQueryParams map[string]bool
+	dynamicQuery     bool
 }
 
 func (r clientRoute) String() string { return r.method + " " + r.path }
@@ -563,6 +979,18 @@ func parseClientRoute(call *ast.CallExpr, pass *analysis.Pass) *clientRoute {
 	sprintfParse := func(r *clientRoute, expr ast.Expr) {
 		r.callPos = call.Pos()
 		r.queryParams = make(map[string]ast.Expr)
+		r.constQueryParams = make(map[string]bool)
+		if i := strings.Index(r.path, "?"); i != -1 {
+			for _, part := range strings.Split(r.path[i+1:], "&") {
+				if j := strings.Index(part, "="); j == -1 {
+					r.dynamicQuery = true
+				} else if !strings.HasPrefix(part[j:], "=%") {
+					r.constQueryParams[part[:j]] = true
+				}
+			}
+		} else if strings.HasSuffix(r.path, "%s") && !strings.HasSuffix(r.path, "/%s") {
+			r.dynamicQuery = true
+		}
 		if call, ok := expr.(*ast.CallExpr); ok {
 			if sel, ok := call.Fun.(*ast.SelectorExpr); ok && types.ExprString(sel) == "fmt.Sprintf" {
 				nPath := strings.Count(r.path, "/%")
@@ -595,6 +1023,68 @@ func parseClientRoute(call *ast.CallExpr, pass *analysis.Pass) *clientRoute {
 		}
 	}
 
+	if name, id := japeFunc(call, pass.TypesInfo); clientFuncs[name] {
+		// package-level helpers take the client as their second argument and
+		// the route as their third, e.g.
+		// Stream[T any](ctx context.Context, c *Client, route string, opts ...RequestOption)
+		typeArgs := pass.TypesInfo.Instances[id].TypeArgs
+		r := &clientRoute{
+			path: strings.TrimPrefix(evalConstString(call.Args[2], pass.TypesInfo), clientPrefix),
+		}
+		var opts []ast.Expr
+		switch name {
+		case "Stream":
+			r.method = "GET"
+			r.stream = "EncodeStream"
+			r.respType = typeArgs.At(0)
+			opts = call.Args[3:]
+		case "Subscribe":
+			r.method = "GET"
+			r.stream = "Events"
+			r.respType = typeArgs.At(0)
+			opts = call.Args[3:]
+		case "GET":
+			r.method = "GET"
+			r.respType = typeArgs.At(0)
+			opts = call.Args[3:]
+		case "POST", "PATCH":
+			r.method = name
+			r.reqType = typeArgs.At(0)
+			r.respType = typeArgs.At(1)
+			opts = call.Args[4:]
+		case "PUT":
+			r.method = "PUT"
+			r.reqType = typeArgs.At(0)
+			opts = call.Args[4:]
+		case "Paginate":
+			r.method = "GET"
+			r.paginated = true
+			r.respType = typeArgs.At(0)
+			opts = call.Args[3:]
+		case "Watch":
+			r.method = "GET"
+			r.blocking = true
+			r.respType = typeArgs.At(0)
+			opts = call.Args[4:]
+		case "DialWebSocket":
+			// the client reads what the server writes, and vice versa
+			r.method = "GET"
+			r.stream = "UpgradeWebSocket"
+			r.respType = typeArgs.At(0)
+			r.reqType = typeArgs.At(1)
+			opts = call.Args[3:]
+		case "POSTStream":
+			r.method = "POST"
+			r.streamRequest = "DecodeStream"
+			r.reqType = typeArgs.At(0)
+			r.response = call.Args[4]
+			opts = call.Args[5:]
+		}
+		sprintfParse(r, call.Args[2])
+		parseRequestOptions(r, opts, call.Ellipsis.IsValid(), pass)
+		return r
+	}
+
 	if call.Fun.(*ast.SelectorExpr).Sel.Name == "Custom" {
 		r := &clientRoute{
 			method:   evalConstString(call.Args[0], pass.TypesInfo),
@@ -606,6 +1096,30 @@ func parseClientRoute(call *ast.CallExpr, pass *analysis.Pass) *clientRoute {
 		return r
 	}
 
+	switch call.Fun.(*ast.SelectorExpr).Sel.Name {
+	case "Upload":
+		// Upload(ctx, method, route string, body io.Reader, size int64, r any, opts ...RequestOption)
+		r := &clientRoute{
+			method:        evalConstString(call.Args[1], pass.TypesInfo),
+			path:          strings.TrimPrefix(evalConstString(call.Args[2], pass.TypesInfo), clientPrefix),
+			response:      call.Args[5],
+			streamRequest: "ReadBody",
+		}
+		sprintfParse(r, call.Args[2])
+		parseRequestOptions(r, call.Args[6:], call.Ellipsis.IsValid(), pass)
+		return r
+	case "Download":
+		// Download(ctx, route string, opts ...RequestOption)
+		r := &clientRoute{
+			method: "GET",
+			path:   strings.TrimPrefix(evalConstString(call.Args[1], pass.TypesInfo), clientPrefix),
+			stream: "WriteBody",
+		}
+		sprintfParse(r, call.Args[1])
+		parseRequestOptions(r, call.Args[2:], call.Ellipsis.IsValid(), pass)
+		return r
+	}
+
 	// We need call.Args[1].  Example:
 	// GET(ctx context.Context, route string, r interface{}) error
 	// call.Args[0] = context
@@ -616,22 +1130,60 @@ func parseClientRoute(call *ast.CallExpr, pass *analysis.Pass) *clientRoute {
 		path:   strings.TrimPrefix(evalConstString(call.Args[1], pass.TypesInfo), clientPrefix),
 	}
 
+	var opts []ast.Expr
 	switch r.method {
 	case "GET":
 		r.response = call.Args[2]
+		opts = call.Args[3:]
 	case "POST":
 		r.request = call.Args[2]
 		r.response = call.Args[3]
+		opts = call.Args[4:]
 	case "PUT":
 		r.request = call.Args[2]
+		opts = call.Args[3:]
+	case "DELETE", "HEAD", "OPTIONS":
+		opts = call.Args[2:]
+	case "PUTWithResponse", "DELETEWithBody":
+		r.method = strings.TrimSuffix(strings.TrimSuffix(r.method, "WithResponse"), "WithBody")
+		r.request = call.Args[2]
+		r.response = call.Args[3]
+		opts = call.Args[4:]
 	case "PATCH":
 		r.request = call.Args[2]
 		r.response = call.Args[3]
+		opts = call.Args[4:]
 	}
 	sprintfParse(r, call.Args[1])
+	parseRequestOptions(r, opts, call.Ellipsis.IsValid(), pass)
 	return r
 }
 
+// parseRequestOptions records the headers, query parameters, and status code
+// referenced by the RequestOptions passed to a client method.
+func parseRequestOptions(r *clientRoute, opts []ast.Expr, ellipsis bool, pass *analysis.Pass) {
+	r.reqHeaders = make(map[string]ast.Expr)
+	r.respHeaders = make(map[string]ast.Expr)
+	if ellipsis {
+		r.dynamicQuery = true // can't inspect a slice of options
+		return
+	}
+	for _, opt := range opts {
+		name, _ := japeFunc(opt, pass.TypesInfo)
+		call, _ := opt.(*ast.CallExpr)
+		switch name {
+		case "WithHeader":
+			r.reqHeaders[textproto.CanonicalMIMEHeaderKey(evalConstString(call.Args[0], pass.TypesInfo))] = call.Args[1]
+		case "WithQuery":
+			r.queryParams[evalConstString(call.Args[0], pass.TypesInfo)] = call.Args[1]
+		case "ReadHeader":
+			r.respHeaders[textproto.CanonicalMIMEHeaderKey(evalConstString(call.Args[0], pass.TypesInfo))] = call.Args[1]
+		case "ExpectStatus":
+			r.status = call.Args[0]
+		}
+	}
+}
+
 func definesClient(file *ast.File, pass *analysis.Pass) bool {
 	found := false
 	ast.Inspect(file, func(n ast.Node) bool {
@@ -641,6 +1193,9 @@ func definesClient(file *ast.File, pass *analysis.Pass) bool {
 		call, ok := n.(*ast.CallExpr)
 		if !ok {
 			return true
+		} else if name, _ := japeFunc(call, pass.TypesInfo); clientFuncs[name] {
+			found = true
+			return false
 		} else if sel, ok := call.Fun.(*ast.SelectorExpr); !ok {
 			return true
 		} else if typ, ok := pass.TypesInfo.Types[sel.X]; ok && typ.Type.String() == "go.sia.tech/jape.Client" {
@@ -736,11 +1291,13 @@ func run(pass *analysis.Pass) (interface{}, error) {
 			call, ok := n.(*ast.CallExpr)
 			if !ok {
 				return true
+			} else if name, _ := japeFunc(call, clientPass.TypesInfo); clientFuncs[name] {
+				// package-level client helper
 			} else if sel, ok := call.Fun.(*ast.SelectorExpr); !ok {
 				return true
 			} else if typ := typeof(clientPass, sel.X); typ == nil || (typ.String() != "go.sia.tech/jape.Client" && typ.String() != "*go.sia.tech/jape.Client") {
 				return true
-			} else if m := sel.Sel.Name; m != "GET" && m != "POST" && m != "PUT" && m != "PATCH" && m != "DELETE" && m != "Custom" {
+			} else if m := sel.Sel.Name; m != "GET" && m != "POST" && m != "PUT" && m != "PATCH" && m != "DELETE" && m != "HEAD" && m != "OPTIONS" && m != "PUTWithResponse" && m != "DELETEWithBody" && m != "Custom" && m != "Upload" && m != "Download" {
 				return true
 			}
 
@@ -759,8 +1316,12 @@ func run(pass *analysis.Pass) (interface{}, error) {
 	if !finished && len(routes) > 0 && len(clientRoutes) > 0 {
 		finished = true
 		// compare against server
+		derived := make(map[string]*serverRoute)
 		for _, cr := range clientRoutes {
 			sr, ok := routes[cr.normalizedRoute()]
+			if !ok {
+				sr, ok = derivedRoute(cr, derived)
+			}
 			if !ok {
 				pass.Report(analysis.Diagnostic{
 					Pos:     cr.pos,
@@ -790,7 +1351,40 @@ func run(pass *analysis.Pass) (interface{}, error) {
 				return t
 			}
 
-			if cr.request != nil {
+			if cr.paginated && !sr.paginated {
+				pass.Report(analysis.Diagnostic{
+					Pos:     cr.callPos,
+					Message: fmt.Sprintf("Client paginates %v, which does not call DecodePage", sr),
+				})
+			}
+			if cr.blocking && !sr.blocking {
+				pass.Report(analysis.Diagnostic{
+					Pos:     cr.callPos,
+					Message: fmt.Sprintf("Client watches %v, which does not call WaitIndex", sr),
+				})
+			}
+			if cr.streamRequest != sr.streamRequest {
+				var msg string
+				switch {
+				case sr.streamRequest == "":
+					msg = fmt.Sprintf("Client streams request to %v, which does not call %v", sr, cr.streamRequest)
+				case cr.streamRequest == "":
+					msg = fmt.Sprintf("Client does not stream request to %v, which calls %v", sr, sr.streamRequest)
+				default:
+					msg = fmt.Sprintf("Client expects %v to call %v, but it calls %v", sr, cr.streamRequest, sr.streamRequest)
+				}
+				pass.Report(analysis.Diagnostic{
+					Pos:     cr.callPos,
+					Message: msg,
+				})
+			} else if cr.reqType != nil {
+				if want := elem(sr.request); checkTypes && !types.Identical(cr.reqType, want) {
+					pass.Report(analysis.Diagnostic{
+						Pos:     cr.callPos,
+						Message: fmt.Sprintf("Client has wrong request type for %v (got %v, should be %v)", sr, cr.reqType, want),
+					})
+				}
+			} else if cr.request != nil {
 				got := typeof(clientPass, cr.request)
 				want := elem(sr.request)
 				if checkTypes && !types.Identical(got, want) {
@@ -800,7 +1394,35 @@ func run(pass *analysis.Pass) (interface{}, error) {
 					})
 				}
 			}
-			if cr.response != nil {
+			if cr.stream != sr.stream {
+				var msg string
+				switch {
+				case sr.stream == "":
+					msg = fmt.Sprintf("Client streams response from %v, which does not call %v", sr, cr.stream)
+				case cr.stream == "":
+					msg = fmt.Sprintf("Client does not stream response from %v, which calls %v", sr, sr.stream)
+				default:
+					msg = fmt.Sprintf("Client expects %v to call %v, but it calls %v", sr, cr.stream, sr.stream)
+				}
+				pass.Report(analysis.Diagnostic{
+					Pos:     cr.callPos,
+					Message: msg,
+				})
+			} else if cr.paginated {
+				if checkTypes && !isPageOf(sr.response, cr.respType) {
+					pass.Report(analysis.Diagnostic{
+						Pos:     cr.callPos,
+						Message: fmt.Sprintf("Client has wrong response type for %v (got pages of %v, should be %v)", sr, cr.respType, sr.response),
+					})
+				}
+			} else if cr.respType != nil {
+				if checkTypes && !types.Identical(cr.respType, sr.response) {
+					pass.Report(analysis.Diagnostic{
+						Pos:     cr.callPos,
+						Message: fmt.Sprintf("Client has wrong response type for %v (got %v, should be %v)", sr, cr.respType, sr.response),
+					})
+				}
+			} else if cr.response != nil {
 				got := typeof(clientPass, cr.response)
 				want := ptrTo(sr.response)
 				if checkTypes && !types.Identical(got, want) {
@@ -832,7 +1454,9 @@ func run(pass *analysis.Pass) (interface{}, error) {
 			}
 			for name, arg := range cr.queryParams {
 				sq, ok := sr.queryParams[name]
-				if !ok {
+				if !ok && sr.flagParams[name] {
+					continue
+				} else if !ok {
 					pass.Report(analysis.Diagnostic{
 						Pos:     arg.Pos(),
 						Message: fmt.Sprintf("Client references undefined query parameter %q", name),
@@ -848,6 +1472,70 @@ func run(pass *analysis.Pass) (interface{}, error) {
 					})
 				}
 			}
+			for name, arg := range cr.reqHeaders {
+				sh, ok := sr.reqHeaders[name]
+				if !ok {
+					// headers may be read by middleware, or directly from
+					// the request
+					if strictHeaders {
+						pass.Report(analysis.Diagnostic{
+							Pos:     arg.Pos(),
+							Message: fmt.Sprintf("Client sends header %q, which is not read by %v", name, sr),
+						})
+					}
+					continue
+				}
+				got := typeof(clientPass, arg)
+				want := elem(sh)
+				if checkTypes && !types.Identical(got, want) {
+					pass.Report(analysis.Diagnostic{
+						Pos:     arg.Pos(),
+						Message: fmt.Sprintf("Client has wrong type for header %q (got %v, should be %v)", name, got, want),
+					})
+				}
+			}
+			for name, arg := range cr.respHeaders {
+				sh, ok := sr.respHeaders[name]
+				if !ok {
+					// headers may be set by middleware, or directly on the
+					// response
+					if strictHeaders {
+						pass.Report(analysis.Diagnostic{
+							Pos:     arg.Pos(),
+							Message: fmt.Sprintf("Client reads header %q, which is not set by %v", name, sr),
+						})
+					}
+					continue
+				}
+				got := typeof(clientPass, arg)
+				want := ptrTo(sh)
+				if checkTypes && !types.Identical(got, want) {
+					pass.Report(analysis.Diagnostic{
+						Pos:     arg.Pos(),
+						Message: fmt.Sprintf("Client has wrong type for header %q (got %v, should be %v)", name, got, want),
+					})
+				}
+			}
+			if cr.status != nil && len(sr.statuses) > 0 && !sr.dynamicStatus {
+				if tv := clientPass.TypesInfo.Types[cr.status]; tv.Value != nil {
+					if status, _ := constant.Int64Val(tv.Value); !sr.statuses[int(status)] {
+						pass.Report(analysis.Diagnostic{
+							Pos:     cr.status.Pos(),
+							Message: fmt.Sprintf("Client expects status code %v, but %v never writes it", status, sr),
+						})
+					}
+				}
+			}
+			if !cr.dynamicQuery {
+				for name := range sr.requiredParams {
+					if _, ok := cr.queryParams[name]; !ok && !cr.constQueryParams[name] {
+						pass.Report(analysis.Diagnostic{
+							Pos:     cr.callPos,
+							Message: fmt.Sprintf("Client does not send required query parameter %q for %v", name, sr),
+						})
+					}
+				}
+			}
 		}
 
 		for _, sr := range routes {
@@ -0,0 +1,39 @@
+package jape
+
+import (
+	"testing"
+
+	"golang.org/x/tools/go/analysis/analysistest"
+)
+
+// resetAnalyzer clears the state that the analyzer accumulates across
+// packages, so that each test package is checked independently.
+func resetAnalyzer() {
+	mu.Lock()
+	defer mu.Unlock()
+	routes = make(map[string]*serverRoute)
+	clientRoutes = nil
+	finished = false
+	clientPass, serverPass = nil, nil
+}
+
+func TestAnalyzer(t *testing.T) {
+	tests := []struct {
+		pkg   string
+		flags map[*bool]bool
+	}{
+		{pkg: "japecheck"},
+		{pkg: "strictheaders", flags: map[*bool]bool{&strictHeaders: true}},
+		{pkg: "bodies", flags: map[*bool]bool{&allowBodies: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pkg, func(t *testing.T) {
+			resetAnalyzer()
+			for flag, v := range tt.flags {
+				defer func(old bool) { *flag = old }(*flag)
+				*flag = v
+			}
+			analysistest.Run(t, analysistest.TestData(), Analyzer, "./"+tt.pkg)
+		})
+	}
+}
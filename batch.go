@@ -0,0 +1,233 @@
+package jape
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// A BatchRequest is a single request within a batch.
+type BatchRequest struct {
+	Method string          `json:"method"`
+	Path   string          `json:"path"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+// A BatchResponse is the response to a single request within a batch. If the
+// request succeeded, Body contains the JSON response body (if any); otherwise,
+// Error contains the error written by the handler.
+type BatchResponse struct {
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// WithBatchRoute adds a POST route at path that accepts a []BatchRequest and
+// responds with a []BatchResponse. Each request is dispatched in-process
+// through the Mux, so it is subject to the same routing and per-route
+// middleware as a standalone request, and inherits the headers of the batch
+// request (e.g. for authentication). At most parallelism requests are handled
+// at once.
+func WithBatchRoute(path string, parallelism int) MuxOption {
+	if parallelism < 1 {
+		panic("parallelism must be positive")
+	}
+	return func(cfg *muxConfig) {
+		cfg.batchPath = path
+		cfg.batchParallelism = parallelism
+	}
+}
+
+// A batchResponseWriter records the response to a single batched request.
+type batchResponseWriter struct {
+	header http.Header
+	status int
+	buf    bytes.Buffer
+}
+
+func (w *batchResponseWriter) Header() http.Header { return w.header }
+
+func (w *batchResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *batchResponseWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.buf.Write(p)
+}
+
+func (w *batchResponseWriter) response() BatchResponse {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	resp := BatchResponse{Status: w.status}
+	body := bytes.TrimSpace(w.buf.Bytes())
+	switch {
+	case w.status < 200 || w.status >= 300:
+		resp.Error = string(body)
+	case len(body) == 0:
+	case json.Valid(body):
+		resp.Body = json.RawMessage(body)
+	default:
+		resp.Status = http.StatusInternalServerError
+		resp.Error = "batched response is not valid JSON"
+	}
+	return resp
+}
+
+// batchKey marks the context of a request dispatched by batchHandler.
+type batchKey struct{}
+
+// batchHandler returns a Handler that dispatches each request in a batch to h.
+func batchHandler(h http.Handler, cfg *muxConfig) Handler {
+	return func(c Context) {
+		if c.Request.Context().Value(batchKey{}) != nil {
+			c.Error(errors.New("batch requests cannot be nested"), http.StatusBadRequest)
+			return
+		}
+		ctx := context.WithValue(c.Request.Context(), batchKey{}, true)
+		var reqs []BatchRequest
+		if c.Decode(&reqs) != nil {
+			return
+		}
+		resps := make([]BatchResponse, len(reqs))
+		sem := make(chan struct{}, cfg.batchParallelism)
+		var wg sync.WaitGroup
+		for i, br := range reqs {
+			req, err := http.NewRequestWithContext(ctx, br.Method, br.Path, bytes.NewReader(br.Body))
+			if err != nil || !strings.HasPrefix(br.Path, "/") {
+				resps[i] = BatchResponse{Status: http.StatusBadRequest, Error: fmt.Sprintf("invalid batch request %q", br.Method+" "+br.Path)}
+				continue
+			}
+			req.Header = c.Request.Header.Clone()
+			for _, h := range []string{"Content-Length", "Accept", "Accept-Encoding"} {
+				req.Header.Del(h)
+			}
+			req.Header.Set("Content-Type", JSONCodec.ContentType())
+			req.Host = c.Request.Host
+			req.RemoteAddr = c.Request.RemoteAddr
+			req.RequestURI = br.Path
+
+			wg.Add(1)
+			sem <- struct{}{}
+			go func() {
+				defer func() { <-sem; wg.Done() }()
+				defer func() {
+					// net/http only recovers panics in its own goroutines
+					if r := recover(); r != nil {
+						c.reportError(fmt.Errorf("panic in batched request %v %v: %v", req.Method, req.URL.Path, r))
+						resps[i] = BatchResponse{Status: http.StatusInternalServerError, Error: http.StatusText(http.StatusInternalServerError)}
+					}
+				}()
+				w := &batchResponseWriter{header: make(http.Header)}
+				h.ServeHTTP(w, req)
+				resps[i] = w.response()
+			}()
+		}
+		wg.Wait()
+		c.Encode(resps)
+	}
+}
+
+// A Batch accumulates requests to be sent to a route added by WithBatchRoute.
+// Requests are added with the GET, POST, PUT, DELETE, and PATCH methods, and
+// sent with Do.
+type Batch struct {
+	c     *Client
+	route string
+	reqs  []BatchRequest
+	resps []any
+	err   error // first encoding error, if any
+}
+
+// Batch returns a new Batch that is sent to the specified route.
+func (c *Client) Batch(route string) *Batch {
+	return &Batch{c: c, route: route}
+}
+
+func (b *Batch) add(method, route string, d, r any) {
+	var body []byte
+	if d != nil {
+		var err error
+		if body, err = json.Marshal(d); err != nil && b.err == nil {
+			b.err = fmt.Errorf("couldn't encode request type (%T): %w", d, err)
+		}
+	}
+	b.reqs = append(b.reqs, BatchRequest{Method: method, Path: route, Body: body})
+	b.resps = append(b.resps, r)
+}
+
+// GET adds a GET request to the batch. Its response is decoded into r.
+func (b *Batch) GET(route string, r any) {
+	b.add(http.MethodGet, route, nil, r)
+}
+
+// POST adds a POST request to the batch. If d is non-nil, it is encoded as the
+// request body. If r is non-nil, the response is decoded into it.
+func (b *Batch) POST(route string, d, r any) {
+	b.add(http.MethodPost, route, d, r)
+}
+
+// PUT adds a PUT request to the batch, encoding d as the request body.
+func (b *Batch) PUT(route string, d any) {
+	b.add(http.MethodPut, route, d, nil)
+}
+
+// DELETE adds a DELETE request to the batch.
+func (b *Batch) DELETE(route string) {
+	b.add(http.MethodDelete, route, nil, nil)
+}
+
+// PATCH adds a PATCH request to the batch. If d is non-nil, it is encoded as
+// the request body. If r is non-nil, the response is decoded into it.
+func (b *Batch) PATCH(route string, d, r any) {
+	b.add(http.MethodPatch, route, d, r)
+}
+
+// Len returns the number of requests in the batch.
+func (b *Batch) Len() int { return len(b.reqs) }
+
+// Do sends the batch. If the batch itself fails, or a request body could not
+// be encoded, Do returns an error. Otherwise, it decodes each successful
+// response into the value supplied when the request was added, and returns the
+// error (if any) of each request, in the order they were added.
+func (b *Batch) Do(ctx context.Context, opts ...RequestOption) ([]error, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	var resps []BatchResponse
+	opts = append([]RequestOption{
+		WithHeader("Content-Type", JSONCodec.ContentType()),
+		WithHeader("Accept", JSONCodec.ContentType()),
+	}, opts...)
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(b.reqs); err != nil {
+		return nil, fmt.Errorf("couldn't encode batch: %w", err)
+	}
+	r, err := b.c.do(ctx, http.MethodPost, b.route, &buf, opts...)
+	if err != nil {
+		return nil, err
+	} else if err := b.c.decodeResponse(r, &resps); err != nil {
+		return nil, err
+	} else if len(resps) != len(b.reqs) {
+		return nil, fmt.Errorf("batch contained %v requests, but server returned %v responses", len(b.reqs), len(resps))
+	}
+
+	errs := make([]error, len(b.reqs))
+	for i, resp := range resps {
+		switch {
+		case resp.Status < 200 || resp.Status >= 300:
+			errs[i] = errors.New(resp.Error)
+		case b.resps[i] != nil && len(resp.Body) > 0:
+			errs[i] = json.Unmarshal(resp.Body, b.resps[i])
+		}
+	}
+	return errs, nil
+}
@@ -0,0 +1,124 @@
+package jape
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// ReadBody calls fn with the raw request body, limited to n bytes, allowing
+// handlers to accept uploads without buffering them in memory. If the body is
+// larger than n bytes, ReadBody writes an error (with status code 413) to the
+// response body and returns it. If fn returns any other error, ReadBody writes
+// it with status code 500 and returns it. Otherwise, the handler is
+// responsible for writing the response.
+func (c Context) ReadBody(n int64, fn func(io.Reader) error) error {
+	c.Request.Body = http.MaxBytesReader(c.ResponseWriter, c.Request.Body, n)
+	if err := fn(c.Request.Body); err != nil {
+		var tooLargeErr *http.MaxBytesError
+		if errors.As(err, &tooLargeErr) {
+			return c.Error(errors.New("request body too large"), http.StatusRequestEntityTooLarge)
+		}
+		return c.Error(fmt.Errorf("couldn't read request body: %w", err), http.StatusInternalServerError)
+	}
+	return nil
+}
+
+// WriteBody copies r to the response body with status code 200. If size is
+// non-negative, it is sent as the Content-Length. The Content-Type defaults to
+// application/octet-stream; it can be overridden with SetHeader. If copying
+// fails, the error is reported to the Mux's error hook, and the client
+// receives a truncated body.
+func (c Context) WriteBody(r io.Reader, size int64) {
+	h := c.ResponseWriter.Header()
+	if h.Get("Content-Type") == "" {
+		h.Set("Content-Type", "application/octet-stream")
+	}
+	if size >= 0 {
+		h.Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+	c.ResponseWriter.WriteHeader(http.StatusOK)
+	if c.Request.Method == http.MethodHead {
+		return
+	}
+	if _, err := io.Copy(c.ResponseWriter, r); err != nil && c.Request.Context().Err() == nil {
+		c.reportError(fmt.Errorf("couldn't write response body: %w", err))
+	}
+}
+
+// A progressReader calls fn after each read.
+type progressReader struct {
+	r     io.ReadCloser
+	n     int64
+	total int64
+	fn    func(n, total int64)
+}
+
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	if n > 0 {
+		pr.n += int64(n)
+		pr.fn(pr.n, pr.total)
+	}
+	return n, err
+}
+
+func (pr *progressReader) Close() error { return pr.r.Close() }
+
+// WithProgress calls fn as the body of a request made by Upload is sent, or as
+// the body of a response returned by Download is read, with the number of
+// bytes transferred so far and the total size. If the size is unknown, total
+// is -1.
+func WithProgress(fn func(n, total int64)) RequestOption {
+	return func(o *requestOptions) {
+		if o.req.Body != nil && o.req.Body != http.NoBody {
+			total := o.req.ContentLength
+			if total == 0 {
+				total = -1
+			}
+			o.req.Body = &progressReader{r: o.req.Body, total: total, fn: fn}
+			return
+		}
+		o.onResponse = append(o.onResponse, func(r *http.Response) error {
+			r.Body = &progressReader{r: r.Body, total: r.ContentLength, fn: fn}
+			return nil
+		})
+	}
+}
+
+// Upload performs a request with the specified method whose body is read from
+// body, which should be handled by a route that calls Context.ReadBody. If
+// size is non-negative, it is sent as the Content-Length; otherwise, the body
+// is sent with chunked encoding. The Content-Type defaults to
+// application/octet-stream; it can be overridden with WithHeader. If r is
+// non-nil, the response is decoded into it.
+func (c *Client) Upload(ctx context.Context, method, route string, body io.Reader, size int64, r any, opts ...RequestOption) error {
+	opts = append([]RequestOption{func(o *requestOptions) {
+		if size >= 0 {
+			o.req.ContentLength = size
+			if size == 0 {
+				o.req.Body = http.NoBody
+			}
+		}
+		o.req.Header.Set("Content-Type", "application/octet-stream")
+	}}, opts...)
+	resp, err := c.do(ctx, method, route, body, opts...)
+	if err != nil {
+		return err
+	}
+	return c.decodeResponse(resp, r)
+}
+
+// Download performs a GET request to a route that calls Context.WriteBody,
+// returning the response body and its size, or -1 if the size is unknown. The
+// caller is responsible for closing the body.
+func (c *Client) Download(ctx context.Context, route string, opts ...RequestOption) (io.ReadCloser, int64, error) {
+	resp, err := c.do(ctx, http.MethodGet, route, nil, opts...)
+	if err != nil {
+		return nil, 0, err
+	}
+	return resp.Body, resp.ContentLength, nil
+}
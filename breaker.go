@@ -0,0 +1,207 @@
+package jape
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// A CircuitState is the state of a circuit breaker.
+type CircuitState int
+
+// Possible circuit breaker states.
+const (
+	// CircuitClosed allows requests to proceed.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen rejects requests immediately.
+	CircuitOpen
+	// CircuitHalfOpen allows a single probe request to proceed; its outcome
+	// determines whether the circuit closes or reopens.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("CircuitState(%d)", int(s))
+	}
+}
+
+// A CircuitOpenError is returned by a Client when a request is rejected by its
+// circuit breaker.
+type CircuitOpenError struct {
+	Host    string
+	RetryAt time.Time
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker open for %v; retry after %v", e.Host, e.RetryAt.Format(time.RFC3339))
+}
+
+type circuit struct {
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// A CircuitBreaker tracks the health of each host contacted by a Client. After
+// a number of consecutive failures (network errors, or responses with status
+// code 502, 503, or 504), the circuit for that host opens, and requests fail
+// immediately with a *CircuitOpenError instead of waiting for the host to
+// respond. Once the cooldown has elapsed, a single probe request is allowed;
+// if it succeeds, the circuit closes, and otherwise it reopens. Requests whose
+// context is canceled or expires are not counted.
+//
+// The zero value is ready to use. A CircuitBreaker may be shared by multiple
+// Clients.
+type CircuitBreaker struct {
+	// FailureThreshold is the number of consecutive failures that opens a
+	// circuit. If zero, 5 is used.
+	FailureThreshold int
+	// Cooldown is how long a circuit remains open before allowing a probe
+	// request. If zero, 30 seconds is used.
+	Cooldown time.Duration
+	// OnStateChange, if set, is called whenever a host's circuit changes
+	// state, e.g. to update metrics. It is called without any locks held, but
+	// must not block.
+	OnStateChange func(host string, from, to CircuitState)
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+func (cb *CircuitBreaker) threshold() int {
+	if cb.FailureThreshold > 0 {
+		return cb.FailureThreshold
+	}
+	return 5
+}
+
+func (cb *CircuitBreaker) cooldown() time.Duration {
+	if cb.Cooldown > 0 {
+		return cb.Cooldown
+	}
+	return 30 * time.Second
+}
+
+// State returns the current state of the circuit for host.
+func (cb *CircuitBreaker) State(host string) CircuitState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if c, ok := cb.circuits[host]; ok {
+		return c.state
+	}
+	return CircuitClosed
+}
+
+// setState transitions c to state, returning a function that reports the
+// change. The caller must hold cb.mu.
+func (cb *CircuitBreaker) setState(host string, c *circuit, state CircuitState) func() {
+	from := c.state
+	if from == state {
+		return func() {}
+	}
+	c.state = state
+	if state == CircuitOpen {
+		c.openedAt = time.Now()
+	}
+	if cb.OnStateChange == nil {
+		return func() {}
+	}
+	return func() { cb.OnStateChange(host, from, state) }
+}
+
+// allow reports whether a request to host may proceed.
+func (cb *CircuitBreaker) allow(host string) error {
+	cb.mu.Lock()
+	if cb.circuits == nil {
+		cb.circuits = make(map[string]*circuit)
+	}
+	c, ok := cb.circuits[host]
+	if !ok {
+		c = new(circuit)
+		cb.circuits[host] = c
+	}
+	notify := func() {}
+	var err error
+	switch c.state {
+	case CircuitOpen:
+		if retryAt := c.openedAt.Add(cb.cooldown()); time.Now().Before(retryAt) {
+			err = &CircuitOpenError{Host: host, RetryAt: retryAt}
+		} else {
+			notify = cb.setState(host, c, CircuitHalfOpen)
+			c.probing = true
+		}
+	case CircuitHalfOpen:
+		if c.probing {
+			err = &CircuitOpenError{Host: host, RetryAt: time.Now()}
+		} else {
+			c.probing = true
+		}
+	}
+	cb.mu.Unlock()
+	notify()
+	return err
+}
+
+// record records the outcome of a request to host.
+func (cb *CircuitBreaker) record(host string, failed bool) {
+	cb.mu.Lock()
+	c := cb.circuits[host]
+	notify := func() {}
+	if c.state == CircuitHalfOpen {
+		c.probing = false
+	}
+	if !failed {
+		c.failures = 0
+		notify = cb.setState(host, c, CircuitClosed)
+	} else if c.failures++; c.state == CircuitHalfOpen || c.failures >= cb.threshold() {
+		notify = cb.setState(host, c, CircuitOpen)
+	}
+	cb.mu.Unlock()
+	notify()
+}
+
+// hostFailure reports whether the result of a request indicates that the host
+// is unhealthy. Other 5xx responses (e.g. 500 Internal Server Error) are
+// typically caused by the request itself, not the host.
+func hostFailure(r *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch r.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// wrap returns a function that sends requests with send, subject to the
+// circuit breaker.
+func (cb *CircuitBreaker) wrap(send func(*http.Request) (*http.Response, error)) func(*http.Request) (*http.Response, error) {
+	return func(req *http.Request) (*http.Response, error) {
+		host := req.URL.Host
+		if err := cb.allow(host); err != nil {
+			return nil, err
+		}
+		r, err := send(req)
+		if req.Context().Err() != nil {
+			// the caller gave up or timed out; this says nothing about the
+			// host's health, but a half-open probe must still be released
+			cb.mu.Lock()
+			cb.circuits[host].probing = false
+			cb.mu.Unlock()
+		} else {
+			cb.record(host, hostFailure(r, err))
+		}
+		return r, err
+	}
+}
@@ -1,80 +1,477 @@
 package jape
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"strings"
+	"time"
+
+	"lukechampine.com/frand"
 )
 
 // A Client provides methods for interacting with an API server.
 type Client struct {
 	BaseURL  string
 	Password string
+
+	// Codec, if set, is used to encode request bodies, and is requested via
+	// the Accept header for responses. Responses are decoded with Codec if the
+	// server honors the request, and as JSON otherwise.
+	Codec Codec
+
+	// IdempotencyKeys, if true, causes POST and PATCH requests to be sent with
+	// a randomly-generated Idempotency-Key header, allowing servers using the
+	// Idempotent middleware to deduplicate them if they are retried. Keys are
+	// also generated whenever Retry is set, so that POST and PATCH requests
+	// can be retried. A key supplied via WithHeader takes precedence; setting
+	// it to "" prevents the request from being retried.
+	IdempotencyKeys bool
+
+	// HTTPClient, if set, is used to send requests. Otherwise, a shared client
+	// whose transport is tuned for connection reuse (see NewTransport) is used.
+	HTTPClient *http.Client
+
+	// Timeout, if non-zero, limits the duration of requests made by GET, POST,
+	// PUT, DELETE, and PATCH, including reading the response body. It does not
+	// apply to streaming requests, which may remain open indefinitely. The
+	// blocking queries made by Watch and WaitJob are allowed to block for the
+	// requested wait in addition to Timeout.
+	Timeout time.Duration
+
+	// Retry, if set, causes requests that fail due to transient errors to be
+	// retried.
+	Retry *RetryPolicy
+
+	// Breaker, if set, causes requests to fail fast while the server is
+	// unhealthy.
+	Breaker *CircuitBreaker
+
+	// MaxResponseSize, if non-zero, limits the size of response bodies decoded
+	// by GET, POST, PUT, DELETE, and PATCH. Larger responses fail with
+	// ErrResponseTooLarge.
+	MaxResponseSize int64
+
+	// MaxErrorSize limits the size of error messages read from non-2xx
+	// responses; longer messages are truncated. If zero, 64 KiB is used.
+	MaxErrorSize int64
+
+	// DisallowUnknownFields causes JSON responses containing fields that are
+	// not present in the destination type to be rejected, revealing drift
+	// between the client and server.
+	DisallowUnknownFields bool
+
+	// Interceptors wrap every request made by the Client, e.g. to add logging,
+	// metrics, or authentication. The first Interceptor is the outermost.
+	Interceptors []Interceptor
 }
 
-func (c *Client) req(ctx context.Context, method string, route string, data, resp interface{}) error {
-	var body io.Reader
-	if data != nil {
-		js, _ := json.Marshal(data)
-		body = bytes.NewReader(js)
+// ErrResponseTooLarge is returned when a response body exceeds
+// Client.MaxResponseSize.
+var ErrResponseTooLarge = errors.New("response body too large")
+
+// A responseLimitReader returns ErrResponseTooLarge if more than n bytes are
+// read from r.
+type responseLimitReader struct {
+	r io.Reader
+	n int64
+}
+
+func (lr *responseLimitReader) Read(p []byte) (int, error) {
+	if lr.n <= 0 {
+		// check whether the body has actually ended
+		var b [1]byte
+		n, err := lr.r.Read(b[:])
+		if n > 0 {
+			err = ErrResponseTooLarge
+		}
+		return 0, err
+	}
+	if int64(len(p)) > lr.n {
+		p = p[:lr.n]
+	}
+	n, err := lr.r.Read(p)
+	lr.n -= int64(n)
+	return n, err
+}
+
+// NewTransport returns an http.Transport suitable for high-volume traffic to a
+// small number of hosts. Unlike http.DefaultTransport, which keeps at most two
+// idle connections per host, it keeps up to maxIdleConnsPerHost idle
+// connections to each host, so that concurrent requests do not constantly
+// open new connections.
+//
+// Connecting times out after 30 seconds, and the TLS handshake after 10
+// seconds. Requests fail if the server does not begin responding within 11
+// minutes, which is long enough for the longest blocking query allowed by
+// WaitIndex.
+func NewTransport(maxIdleConnsPerHost int) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConns = 0 // no global limit
+	t.MaxIdleConnsPerHost = maxIdleConnsPerHost
+	t.IdleConnTimeout = 90 * time.Second
+	t.TLSHandshakeTimeout = 10 * time.Second
+	t.ExpectContinueTimeout = time.Second
+	t.ResponseHeaderTimeout = maxWait + time.Minute
+	t.DialContext = (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	return t
+}
+
+var defaultHTTPClient = &http.Client{Transport: NewTransport(64)}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return defaultHTTPClient
+}
+
+// maxDrainBytes is the maximum number of unread bytes that are discarded from
+// a response body before closing it. Draining the body allows the connection
+// to be reused; if the remainder is larger than this, it is cheaper to close
+// the connection.
+const maxDrainBytes = 256 << 10 // 256 KiB
+
+// drainAndClose discards the remainder of body (up to maxDrainBytes) and
+// closes it.
+func drainAndClose(body io.ReadCloser) {
+	io.Copy(io.Discard, io.LimitReader(body, maxDrainBytes))
+	body.Close()
+}
+
+func (c *Client) codec() Codec {
+	if c.Codec != nil {
+		return c.Codec
+	}
+	return JSONCodec
+}
+
+type requestOptions struct {
+	req        *http.Request
+	info       RequestInfo
+	onReceive  []func(*http.Response) // called for every response
+	onResponse []func(*http.Response) error
+}
+
+// A RequestOption modifies a request made by a Client, or the handling of its
+// response.
+type RequestOption func(*requestOptions)
+
+// WithHeader sets the request header with the specified name to the encoding of
+// v. If v implements MarshalText, it is used; otherwise, v is formatted with
+// fmt.Sprint.
+func WithHeader(name string, v any) RequestOption {
+	return func(o *requestOptions) {
+		o.req.Header.Set(name, encodeString(v))
+	}
+}
+
+// WithQuery adds a query parameter with the specified key and the encoding of
+// v to the request URL, in addition to any query parameters already present in
+// the route. v is encoded as in WithHeader.
+func WithQuery(key string, v any) RequestOption {
+	return func(o *requestOptions) {
+		q := o.req.URL.Query()
+		q.Add(key, encodeString(v))
+		o.req.URL.RawQuery = q.Encode()
+	}
+}
+
+// ResponseMeta contains metadata about a response.
+type ResponseMeta struct {
+	StatusCode    int
+	Header        http.Header
+	ContentLength int64
+}
+
+// CaptureResponse stores the status code, headers, and content length of the
+// response in m. Unlike ReadHeader, it does so even if the response has a
+// non-2xx status code, e.g. 304 Not Modified in response to If-None-Match.
+func CaptureResponse(m *ResponseMeta) RequestOption {
+	return func(o *requestOptions) {
+		o.onReceive = append(o.onReceive, func(r *http.Response) {
+			*m = ResponseMeta{
+				StatusCode:    r.StatusCode,
+				Header:        r.Header,
+				ContentLength: r.ContentLength,
+			}
+		})
+	}
+}
+
+// ReadHeader decodes the response header with the specified name into v, using
+// the same rules as Context.DecodeHeader. If the header is absent, v is
+// unchanged.
+func ReadHeader(name string, v any) RequestOption {
+	return func(o *requestOptions) {
+		o.onResponse = append(o.onResponse, func(r *http.Response) error {
+			value := r.Header.Get(name)
+			if value == "" {
+				return nil
+			} else if err := decodeString(value, v); err != nil {
+				return fmt.Errorf("couldn't decode response header %q: %w", name, err)
+			}
+			return nil
+		})
+	}
+}
+
+// ExpectStatus causes the request to fail if the server responds with a
+// success status code other than status.
+func ExpectStatus(status int) RequestOption {
+	return func(o *requestOptions) {
+		o.onResponse = append(o.onResponse, func(r *http.Response) error {
+			if r.StatusCode != status {
+				return fmt.Errorf("unexpected status code %v (expected %v)", r.StatusCode, status)
+			}
+			return nil
+		})
+	}
+}
+
+// send sends req through the Client's interceptors, retry policy, and circuit
+// breaker.
+func (c *Client) send(req *http.Request, info RequestInfo) (*http.Response, error) {
+	send := c.httpClient().Do
+	if c.Breaker != nil {
+		send = c.Breaker.wrap(send)
+	}
+	if c.Retry != nil {
+		retry, inner := c.Retry, send
+		send = func(req *http.Request) (*http.Response, error) { return retry.do(inner, req) }
+	}
+	for i := len(c.Interceptors) - 1; i >= 0; i-- {
+		ic, next := c.Interceptors[i], send
+		send = func(req *http.Request) (*http.Response, error) { return ic(info, req, next) }
 	}
+	return send(req)
+}
+
+// do sends a request with the specified body and returns the response. If the
+// response has a non-2xx status code, its body is returned as an error. The
+// caller is responsible for closing the response body.
+func (c *Client) do(ctx context.Context, method string, route string, body io.Reader, opts ...RequestOption) (*http.Response, error) {
 	req, err := http.NewRequestWithContext(ctx, method, fmt.Sprintf("%v%v", c.BaseURL, route), body)
 	if err != nil {
 		panic(err)
 	}
-	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Type", c.codec().ContentType())
+	if c.Codec != nil {
+		req.Header.Set("Accept", c.Codec.ContentType())
+	}
 	if c.Password != "" {
 		req.SetBasicAuth("", c.Password)
 	}
-	r, err := http.DefaultClient.Do(req)
+	if (c.IdempotencyKeys || c.Retry != nil) && (method == http.MethodPost || method == http.MethodPatch) {
+		req.Header.Set(IdempotencyKeyHeader, hex.EncodeToString(frand.Bytes(16)))
+	}
+	path, _, _ := strings.Cut(route, "?")
+	ro := requestOptions{req: req, info: RequestInfo{Method: method, Route: path}}
+	for _, opt := range opts {
+		opt(&ro)
+	}
+	r, err := c.send(req, ro.info)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	for _, fn := range ro.onReceive {
+		fn(r)
 	}
-	defer io.Copy(io.Discard, r.Body)
-	defer r.Body.Close()
 	if !(200 <= r.StatusCode && r.StatusCode < 300) {
-		err, _ := io.ReadAll(r.Body)
-		return errors.New(strings.TrimSpace(string(err)))
+		return nil, c.responseError(r)
+	}
+	for _, fn := range ro.onResponse {
+		if err := fn(r); err != nil {
+			drainAndClose(r.Body)
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// responseError reads the error message from the body of r, which has an
+// unexpected status code, and closes it. Messages longer than c.MaxErrorSize
+// are truncated.
+func (c *Client) responseError(r *http.Response) error {
+	defer drainAndClose(r.Body)
+	maxErr := c.MaxErrorSize
+	if maxErr <= 0 {
+		maxErr = 64 << 10 // 64 KiB
+	}
+	err, _ := io.ReadAll(io.LimitReader(r.Body, maxErr))
+	if len(bytes.TrimSpace(err)) == 0 {
+		return errors.New(r.Status) // e.g. 304 Not Modified
+	}
+	return errors.New(strings.TrimSpace(string(err)))
+}
+
+func (c *Client) req(ctx context.Context, method string, route string, data, resp interface{}, opts ...RequestOption) error {
+	return c.reqTimeout(ctx, c.Timeout, method, route, data, resp, opts...)
+}
+
+// reqTimeout is like req, but limits the request to timeout rather than
+// c.Timeout.
+func (c *Client) reqTimeout(ctx context.Context, timeout time.Duration, method string, route string, data, resp interface{}, opts ...RequestOption) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	codec := c.codec()
+	var body io.Reader
+	if data != nil {
+		var buf bytes.Buffer
+		if err := codec.Encode(&buf, data); err != nil {
+			return fmt.Errorf("couldn't encode request type (%T): %w", data, err)
+		}
+		body = &buf
+	}
+	r, err := c.do(ctx, method, route, body, opts...)
+	if err != nil {
+		return err
 	}
+	return c.decodeResponse(r, resp)
+}
+
+// An optionalResponse is a response destination that is left unchanged if the
+// response has status code 204 or an empty body.
+type optionalResponse struct{ v any }
+
+// decodeResponse decodes the body of r into resp, if resp is non-nil, and
+// closes it.
+func (c *Client) decodeResponse(r *http.Response, resp any) error {
+	defer drainAndClose(r.Body)
 	if resp == nil {
 		return nil
 	}
-	return json.NewDecoder(r.Body).Decode(resp)
+	body := io.Reader(r.Body)
+	if c.MaxResponseSize > 0 {
+		body = &responseLimitReader{r: body, n: c.MaxResponseSize}
+	}
+	if or, ok := resp.(optionalResponse); ok {
+		if r.StatusCode == http.StatusNoContent {
+			return nil
+		}
+		br := bufio.NewReader(body)
+		if _, err := br.Peek(1); err == io.EOF {
+			return nil
+		}
+		body, resp = br, or.v
+	}
+	codec := codecFor(r.Header.Get("Content-Type"), []Codec{c.codec()})
+	if codec == nil {
+		codec = JSONCodec
+	}
+	if codec == JSONCodec && c.DisallowUnknownFields {
+		dec := json.NewDecoder(body)
+		dec.DisallowUnknownFields()
+		return dec.Decode(resp)
+	}
+	return codec.Decode(body, resp)
 }
 
 // GET performs a GET request, decoding the response into r.
-func (c *Client) GET(ctx context.Context, route string, r interface{}) error {
-	return c.req(ctx, http.MethodGet, route, nil, r)
+func (c *Client) GET(ctx context.Context, route string, r interface{}, opts ...RequestOption) error {
+	return c.req(ctx, http.MethodGet, route, nil, r, opts...)
 }
 
 // POST performs a POST request. If d is non-nil, it is encoded as the request
 // body. If r is non-nil, the response is decoded into it.
-func (c *Client) POST(ctx context.Context, route string, d, r interface{}) error {
-	return c.req(ctx, http.MethodPost, route, d, r)
+func (c *Client) POST(ctx context.Context, route string, d, r interface{}, opts ...RequestOption) error {
+	return c.req(ctx, http.MethodPost, route, d, r, opts...)
 }
 
 // PUT performs a PUT request, encoding d as the request body.
-func (c *Client) PUT(ctx context.Context, route string, d interface{}) error {
-	return c.req(ctx, http.MethodPut, route, d, nil)
+func (c *Client) PUT(ctx context.Context, route string, d interface{}, opts ...RequestOption) error {
+	return c.req(ctx, http.MethodPut, route, d, nil, opts...)
 }
 
 // DELETE performs a DELETE request.
-func (c *Client) DELETE(ctx context.Context, route string) error {
-	return c.req(ctx, http.MethodDelete, route, nil, nil)
+func (c *Client) DELETE(ctx context.Context, route string, opts ...RequestOption) error {
+	return c.req(ctx, http.MethodDelete, route, nil, nil, opts...)
+}
+
+// PUTWithResponse performs a PUT request, encoding d as the request body and
+// decoding the response into r. Routes that write a response to PUT requests
+// must be checked with japecheck's -bodies flag.
+func (c *Client) PUTWithResponse(ctx context.Context, route string, d, r interface{}, opts ...RequestOption) error {
+	return c.req(ctx, http.MethodPut, route, d, r, opts...)
+}
+
+// DELETEWithBody performs a DELETE request. If d is non-nil, it is encoded as
+// the request body. If r is non-nil, the response is decoded into it. Routes
+// that read or write a body in response to DELETE requests must be checked
+// with japecheck's -bodies flag.
+func (c *Client) DELETEWithBody(ctx context.Context, route string, d, r interface{}, opts ...RequestOption) error {
+	return c.req(ctx, http.MethodDelete, route, d, r, opts...)
 }
 
 // PATCH performs a PATCH request. If d is non-nil, it is encoded as the request
 // body. If r is non-nil, the response is decoded into it.
-func (c *Client) PATCH(ctx context.Context, route string, d, r interface{}) error {
-	return c.req(ctx, http.MethodPatch, route, d, r)
+func (c *Client) PATCH(ctx context.Context, route string, d, r interface{}, opts ...RequestOption) error {
+	return c.req(ctx, http.MethodPatch, route, d, r, opts...)
+}
+
+// HEAD performs a HEAD request, returning the response headers.
+func (c *Client) HEAD(ctx context.Context, route string, opts ...RequestOption) (http.Header, error) {
+	var meta ResponseMeta
+	err := c.req(ctx, http.MethodHead, route, nil, nil, append(opts, CaptureResponse(&meta))...)
+	return meta.Header, err
+}
+
+// OPTIONS performs an OPTIONS request, returning the response headers. The
+// methods allowed for the route are listed in the Allow header.
+func (c *Client) OPTIONS(ctx context.Context, route string, opts ...RequestOption) (http.Header, error) {
+	var meta ResponseMeta
+	err := c.req(ctx, http.MethodOptions, route, nil, nil, append(opts, CaptureResponse(&meta))...)
+	return meta.Header, err
 }
 
 // Custom is a no-op that simply declares the request and response types used by
 // a client method. This allows japecheck to be used on endpoints that do not
 // speak JSON.
 func (c *Client) Custom(_, _ string, _, _ any) {}
+
+// GET performs a GET request, returning the decoded response. It is a
+// type-safe alternative to Client.GET.
+func GET[Resp any](ctx context.Context, c *Client, route string, opts ...RequestOption) (Resp, error) {
+	var resp Resp
+	err := c.req(ctx, http.MethodGet, route, nil, &resp, opts...)
+	return resp, err
+}
+
+// POST performs a POST request, encoding req as the request body and returning
+// the decoded response. If the response has status code 204 or an empty body,
+// the zero value of Resp is returned. It is a type-safe alternative to
+// Client.POST.
+func POST[Req, Resp any](ctx context.Context, c *Client, route string, req Req, opts ...RequestOption) (Resp, error) {
+	var resp Resp
+	err := c.req(ctx, http.MethodPost, route, req, optionalResponse{&resp}, opts...)
+	return resp, err
+}
+
+// PUT performs a PUT request, encoding req as the request body. It is a
+// type-safe alternative to Client.PUT.
+func PUT[Req any](ctx context.Context, c *Client, route string, req Req, opts ...RequestOption) error {
+	return c.req(ctx, http.MethodPut, route, req, nil, opts...)
+}
+
+// PATCH performs a PATCH request, encoding req as the request body and
+// returning the decoded response. If the response has status code 204 or an
+// empty body, the zero value of Resp is returned. It is a type-safe
+// alternative to Client.PATCH.
+func PATCH[Req, Resp any](ctx context.Context, c *Client, route string, req Req, opts ...RequestOption) (Resp, error) {
+	var resp Resp
+	err := c.req(ctx, http.MethodPatch, route, req, optionalResponse{&resp}, opts...)
+	return resp, err
+}
@@ -0,0 +1,146 @@
+package jape
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// A Codec encodes and decodes request and response bodies in a particular
+// format. Codecs are selected by the Content-Type and Accept headers of a
+// request.
+type Codec interface {
+	// ContentType returns the media type handled by the codec, e.g.
+	// "application/json".
+	ContentType() string
+	Encode(w io.Writer, v any) error
+	Decode(r io.Reader, v any) error
+}
+
+// JSONCodec is the default Codec, which uses encoding/json.
+var JSONCodec Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string { return "application/json" }
+
+func (jsonCodec) Encode(w io.Writer, v any) error { return json.NewEncoder(w).Encode(v) }
+
+func (jsonCodec) Decode(r io.Reader, v any) error { return json.NewDecoder(r).Decode(v) }
+
+// BinaryCodec is a Codec for types that implement encoding.BinaryMarshaler and
+// encoding.BinaryUnmarshaler. Its content type is application/octet-stream.
+var BinaryCodec Codec = binaryCodec{}
+
+type binaryCodec struct{}
+
+func (binaryCodec) ContentType() string { return "application/octet-stream" }
+
+func (binaryCodec) Encode(w io.Writer, v any) error {
+	bm, ok := v.(encoding.BinaryMarshaler)
+	if !ok {
+		return fmt.Errorf("%T does not implement encoding.BinaryMarshaler", v)
+	}
+	b, err := bm.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+func (binaryCodec) Decode(r io.Reader, v any) error {
+	bu, ok := v.(encoding.BinaryUnmarshaler)
+	if !ok {
+		return fmt.Errorf("%T does not implement encoding.BinaryUnmarshaler", v)
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return bu.UnmarshalBinary(b)
+}
+
+// codecFor returns the codec in codecs that handles the media type in the
+// specified Content-Type header, or nil if there is none.
+func codecFor(contentType string, codecs []Codec) Codec {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil
+	}
+	for _, c := range codecs {
+		if c.ContentType() == mt {
+			return c
+		}
+	}
+	return nil
+}
+
+// negotiateCodec returns the codec in codecs that the specified Accept header
+// weights most highly, or nil if the client prefers JSON or did not express a
+// preference. A codec's weight is the q-value of the most specific media range
+// that matches it; ties are broken in favor of more specific ranges, then
+// ranges listed earlier in the header, then JSON.
+func negotiateCodec(accept string, codecs []Codec) Codec {
+	type weight struct {
+		q           float64
+		specificity int
+		pos         int
+	}
+	weigh := func(ct string) (w weight) {
+		w.pos = -1
+		typ, _, _ := strings.Cut(ct, "/")
+		for i, part := range strings.Split(accept, ",") {
+			mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil {
+				continue
+			}
+			var specificity int
+			switch mt {
+			case ct:
+				specificity = 3
+			case typ + "/*":
+				specificity = 2
+			case "*/*":
+				specificity = 1
+			default:
+				continue
+			}
+			if specificity <= w.specificity {
+				continue
+			}
+			q := 1.0
+			if s, ok := params["q"]; ok {
+				if q, err = strconv.ParseFloat(s, 64); err != nil || q < 0 || q > 1 {
+					continue
+				}
+			}
+			w = weight{q, specificity, i}
+		}
+		return
+	}
+	better := func(a, b weight) bool {
+		if a.q != b.q {
+			return a.q > b.q
+		} else if a.specificity != b.specificity {
+			return a.specificity > b.specificity
+		}
+		return a.pos < b.pos
+	}
+
+	var best Codec
+	bestWeight := weigh(JSONCodec.ContentType())
+	if bestWeight.pos < 0 {
+		bestWeight.q = 0 // unlisted, but still the fallback
+	}
+	for _, c := range codecs {
+		if w := weigh(c.ContentType()); w.pos >= 0 && w.q > 0 && better(w, bestWeight) {
+			best, bestWeight = c, w
+		}
+	}
+	return best
+}
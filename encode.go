@@ -0,0 +1,167 @@
+package jape
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
+)
+
+// buffers larger than this are not returned to the pool, so that a single huge
+// response doesn't pin its memory indefinitely
+const maxPooledBufferSize = 1 << 20 // 1 MiB
+
+var bufPool = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
+}
+
+func getBuffer() *bytes.Buffer {
+	buf := bufPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBufferSize {
+		bufPool.Put(buf)
+	}
+}
+
+var (
+	jsonMarshalerType = reflect.TypeFor[json.Marshaler]()
+	textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+)
+
+// streamable reports whether values of type t can be encoded one element at a
+// time while producing the same output as json.Marshal.
+func streamable(t reflect.Type) bool {
+	if t.Kind() != reflect.Slice || t.Elem().Kind() == reflect.Uint8 {
+		return false // []byte is encoded as a base64 string
+	}
+	for _, m := range []reflect.Type{jsonMarshalerType, textMarshalerType} {
+		if t.Implements(m) || reflect.PointerTo(t).Implements(m) {
+			return false
+		}
+	}
+	return true
+}
+
+// A responseEncoder encodes a JSON response into a pooled buffer. If a
+// streaming threshold is set, the buffer is flushed to the ResponseWriter
+// whenever it grows beyond the threshold; otherwise, the response is written
+// in one piece with a Content-Length header.
+type responseEncoder struct {
+	w         http.ResponseWriter
+	status    int
+	compact   bool
+	threshold int
+
+	buf       *bytes.Buffer
+	enc       *json.Encoder
+	streaming bool
+}
+
+func newResponseEncoder(w http.ResponseWriter, status int, cfg *muxConfig) *responseEncoder {
+	e := &responseEncoder{
+		w:      w,
+		status: status,
+		buf:    getBuffer(),
+	}
+	if cfg != nil {
+		e.compact = cfg.compactJSON
+		e.threshold = cfg.streamThreshold
+	}
+	e.enc = json.NewEncoder(e.buf)
+	if !e.compact {
+		e.enc.SetIndent("", "  ")
+	}
+	return e
+}
+
+func (e *responseEncoder) writeHeader(contentLength int) {
+	e.w.Header().Set("Content-Type", "application/json")
+	if contentLength >= 0 {
+		e.w.Header().Set("Content-Length", strconv.Itoa(contentLength))
+	}
+	e.w.WriteHeader(e.status)
+}
+
+func (e *responseEncoder) encodeValue(v any) error {
+	if err := e.enc.Encode(v); err != nil {
+		return err
+	}
+	e.buf.Truncate(e.buf.Len() - 1) // trim the newline added by Encode
+	return nil
+}
+
+func (e *responseEncoder) maybeFlush() {
+	if e.threshold <= 0 || e.buf.Len() < e.threshold {
+		return
+	} else if !e.streaming {
+		e.streaming = true
+		e.writeHeader(-1)
+	}
+	e.w.Write(e.buf.Bytes())
+	e.buf.Reset()
+	if f, ok := e.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// encode encodes v. If it returns an error and e.streaming is false, nothing
+// has been written to the ResponseWriter yet.
+func (e *responseEncoder) encode(v any) error {
+	val := reflect.ValueOf(v)
+	switch {
+	// encode nil slices as [] and nil maps as {} (instead of null)
+	case val.Kind() == reflect.Slice && val.Len() == 0:
+		e.buf.WriteString("[]\n")
+	case val.Kind() == reflect.Map && val.Len() == 0:
+		e.buf.WriteString("{}\n")
+	case e.threshold > 0 && streamable(val.Type()):
+		if !e.compact {
+			e.enc.SetIndent("  ", "  ")
+		}
+		e.buf.WriteByte('[')
+		for i := range val.Len() {
+			if i > 0 {
+				e.buf.WriteByte(',')
+			}
+			if !e.compact {
+				e.buf.WriteString("\n  ")
+			}
+			// slice elements are addressable, so json.Marshal would use
+			// pointer-receiver methods; do the same here
+			if err := e.encodeValue(val.Index(i).Addr().Interface()); err != nil {
+				return err
+			}
+			e.maybeFlush()
+		}
+		if !e.compact {
+			e.buf.WriteByte('\n')
+		}
+		e.buf.WriteByte(']')
+	default:
+		if err := e.encodeValue(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// finish writes any remaining buffered output and releases the buffer.
+func (e *responseEncoder) finish() {
+	if !e.streaming {
+		e.writeHeader(e.buf.Len())
+	}
+	e.w.Write(e.buf.Bytes())
+	e.release()
+}
+
+func (e *responseEncoder) release() {
+	putBuffer(e.buf)
+	e.buf = nil
+}
@@ -0,0 +1,268 @@
+package jape
+
+import (
+	"bytes"
+	"container/heap"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// IdempotencyKeyHeader is the request header containing a client-generated
+// key that identifies a logical operation across retries.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// ErrRequestInProgress is returned by an IdempotencyStore when a request with
+// the same idempotency key is still being handled.
+var ErrRequestInProgress = errors.New("a request with this idempotency key is already in progress")
+
+// A StoredResponse is a response recorded by the Idempotent middleware.
+type StoredResponse struct {
+	Status int
+	Header http.Header
+	Body   []byte
+	// RequestHash is the SHA-256 hash of the request body, used to detect
+	// keys that are reused for different requests.
+	RequestHash [32]byte
+}
+
+// An IdempotencyStore stores the responses to idempotent requests.
+// Implementations must be safe for concurrent use.
+type IdempotencyStore interface {
+	// Reserve reserves key for a request that is about to be handled. If a
+	// response has already been stored for key, Reserve returns it instead.
+	// If key is reserved by a request that has not completed, Reserve returns
+	// ErrRequestInProgress.
+	Reserve(key string) (*StoredResponse, error)
+	// Store stores the response for a reserved key, releasing the
+	// reservation. The response may be discarded after ttl.
+	Store(key string, resp StoredResponse, ttl time.Duration) error
+	// Release releases a reservation without storing a response, allowing the
+	// request to be retried.
+	Release(key string) error
+}
+
+type memoryIdempotencyEntry struct {
+	resp    *StoredResponse // nil while in progress
+	expires time.Time
+}
+
+// An expiryHeap orders stored keys by expiration time.
+type expiryHeap []expiryItem
+
+type expiryItem struct {
+	key     string
+	expires time.Time
+}
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].expires.Before(h[j].expires) }
+func (h expiryHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *expiryHeap) Push(x any)        { *h = append(*h, x.(expiryItem)) }
+func (h *expiryHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+type memoryIdempotencyStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryIdempotencyEntry
+	expiry  expiryHeap
+}
+
+// expire deletes stored responses whose ttl has elapsed. The caller must hold
+// s.mu.
+func (s *memoryIdempotencyStore) expire(now time.Time) {
+	for len(s.expiry) > 0 && now.After(s.expiry[0].expires) {
+		item := heap.Pop(&s.expiry).(expiryItem)
+		// the key may have been released or stored again since
+		if e, ok := s.entries[item.key]; ok && e.resp != nil && e.expires.Equal(item.expires) {
+			delete(s.entries, item.key)
+		}
+	}
+}
+
+func (s *memoryIdempotencyStore) Reserve(key string) (*StoredResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(time.Now())
+	if e, ok := s.entries[key]; ok {
+		if e.resp == nil {
+			return nil, ErrRequestInProgress
+		}
+		return e.resp, nil
+	}
+	s.entries[key] = memoryIdempotencyEntry{}
+	return nil, nil
+}
+
+func (s *memoryIdempotencyStore) Store(key string, resp StoredResponse, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expires := time.Now().Add(ttl)
+	s.entries[key] = memoryIdempotencyEntry{resp: &resp, expires: expires}
+	heap.Push(&s.expiry, expiryItem{key: key, expires: expires})
+	return nil
+}
+
+func (s *memoryIdempotencyStore) Release(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+// NewMemoryIdempotencyStore returns an IdempotencyStore that keeps responses
+// in memory.
+func NewMemoryIdempotencyStore() IdempotencyStore {
+	return &memoryIdempotencyStore{entries: make(map[string]memoryIdempotencyEntry)}
+}
+
+// A recordingResponseWriter passes a response through to the client while
+// recording it.
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	buf    bytes.Buffer
+}
+
+func (w *recordingResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.buf.Write(p)
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *recordingResponseWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+type idempotencyConfig struct {
+	scope       func(*http.Request) string
+	maxBodySize int64
+}
+
+// An IdempotencyOption configures the Idempotent middleware.
+type IdempotencyOption func(*idempotencyConfig)
+
+// WithIdempotencyScope sets the function that identifies the principal making
+// a request, e.g. an authenticated user ID. Idempotency keys are scoped to
+// their principal, so that one principal can never receive a response
+// recorded for another. By default, requests are scoped by their
+// Authorization header.
+func WithIdempotencyScope(fn func(*http.Request) string) IdempotencyOption {
+	return func(cfg *idempotencyConfig) {
+		cfg.scope = fn
+	}
+}
+
+// WithMaxIdempotentBodySize sets the maximum size of the request bodies that
+// the Idempotent middleware buffers. Larger requests are rejected with status
+// code 413. The default is 10 MB, matching Decode.
+func WithMaxIdempotentBodySize(n int64) IdempotencyOption {
+	return func(cfg *idempotencyConfig) {
+		cfg.maxBodySize = n
+	}
+}
+
+// Idempotent returns a http.Handler transformer that deduplicates requests
+// carrying an Idempotency-Key header. The first request with a given key is
+// handled normally, and its response is recorded in store for ttl; subsequent
+// requests with the same key (and the same method, path, and principal; see
+// WithIdempotencyScope) receive the recorded response, with the
+// Idempotent-Replayed header set, without invoking the handler. If the
+// subsequent request has a different body, it is rejected with status code
+// 422. While the first request is in progress, duplicates are rejected with
+// status code 409. Responses with a 5xx status code are not recorded, so that
+// the request can be retried. Requests without an Idempotency-Key header are
+// handled normally.
+//
+// The bodies of requests carrying an Idempotency-Key header are buffered in
+// memory, and are limited to 10 MB by default; see WithMaxIdempotentBodySize.
+func Idempotent(store IdempotencyStore, ttl time.Duration, opts ...IdempotencyOption) func(http.Handler) http.Handler {
+	cfg := idempotencyConfig{
+		scope:       func(req *http.Request) string { return req.Header.Get("Authorization") },
+		maxBodySize: 1e7, // 10 MB
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			key := req.Header.Get(IdempotencyKeyHeader)
+			if key == "" {
+				h.ServeHTTP(w, req)
+				return
+			}
+			// hash the scope, so that credentials are not stored in plaintext
+			key = fmt.Sprintf("%v %v %x %v", req.Method, req.URL.Path, sha256.Sum256([]byte(cfg.scope(req))), key)
+
+			body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, cfg.maxBodySize))
+			var tooLargeErr *http.MaxBytesError
+			if errors.As(err, &tooLargeErr) {
+				http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+				return
+			} else if err != nil {
+				http.Error(w, "couldn't read request body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			req.Body = io.NopCloser(bytes.NewReader(body))
+			hash := sha256.Sum256(body)
+
+			stored, err := store.Reserve(key)
+			if errors.Is(err, ErrRequestInProgress) {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			} else if err != nil {
+				http.Error(w, "couldn't check idempotency key: "+err.Error(), http.StatusInternalServerError)
+				return
+			} else if stored != nil {
+				if stored.RequestHash != hash {
+					http.Error(w, "idempotency key was already used for a different request", http.StatusUnprocessableEntity)
+					return
+				}
+				for k, vs := range stored.Header {
+					w.Header()[k] = vs
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(stored.Status)
+				w.Write(stored.Body)
+				return
+			}
+
+			rw := &recordingResponseWriter{ResponseWriter: w}
+			defer func() {
+				if stored == nil {
+					store.Release(key) // allow the request to be retried
+				}
+			}()
+			h.ServeHTTP(rw, req)
+			if rw.status == 0 {
+				rw.status = http.StatusOK
+			}
+			if rw.status < 500 {
+				resp := StoredResponse{
+					Status:      rw.status,
+					Header:      w.Header().Clone(),
+					Body:        rw.buf.Bytes(),
+					RequestHash: hash,
+				}
+				if store.Store(key, resp, ttl) == nil {
+					stored = &resp
+				}
+			}
+		})
+	}
+}
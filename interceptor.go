@@ -0,0 +1,114 @@
+package jape
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// RequestInfo describes a request made by a Client.
+type RequestInfo struct {
+	Method string
+	// Route is the route passed to the Client method, without its query
+	// string, unless overridden with WithRouteTemplate.
+	Route string
+}
+
+// WithRouteTemplate sets the route reported to the Client's interceptors.
+// Routes containing parameters should set it to the server's route (e.g.
+// "/objects/:key"), so that metrics are grouped by route rather than by
+// parameter value.
+func WithRouteTemplate(route string) RequestOption {
+	return func(o *requestOptions) {
+		o.info.Route = route
+	}
+}
+
+// An Interceptor wraps each request made by a Client, including the handshakes
+// made by DialWebSocket, allowing it to inspect or modify the request before
+// calling next to send it, and to inspect or replace the response and error
+// that next returns. Responses with non-2xx status codes are not errors at
+// this stage; the error is non-nil only if no response was received.
+//
+// Interceptors see each request once, even if it is retried or rejected by
+// the Client's circuit breaker. An interceptor that does not return the
+// response from next must close its body.
+type Interceptor func(info RequestInfo, req *http.Request, next func(*http.Request) (*http.Response, error)) (*http.Response, error)
+
+// LogRequests returns an Interceptor that logs the method, route, status code,
+// and duration of each request to l. Requests that fail without a response,
+// or that receive a 5xx response, are logged at the error level; others are
+// logged at the debug level.
+func LogRequests(l *slog.Logger) Interceptor {
+	return func(info RequestInfo, req *http.Request, next func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+		start := time.Now()
+		r, err := next(req)
+		attrs := []slog.Attr{
+			slog.String("method", info.Method),
+			slog.String("route", info.Route),
+			slog.Duration("elapsed", time.Since(start)),
+		}
+		level := slog.LevelDebug
+		if err != nil {
+			level = slog.LevelError
+			attrs = append(attrs, slog.String("error", err.Error()))
+		} else {
+			if r.StatusCode >= 500 {
+				level = slog.LevelError
+			}
+			attrs = append(attrs, slog.Int("status", r.StatusCode))
+		}
+		l.LogAttrs(req.Context(), level, "request completed", attrs...)
+		return r, err
+	}
+}
+
+// ObserveRequests returns an Interceptor that calls fn with the outcome of
+// each request, e.g. to record metrics. If no response was received, status
+// is 0 and err is non-nil. fn must not block.
+func ObserveRequests(fn func(info RequestInfo, status int, elapsed time.Duration, err error)) Interceptor {
+	return func(info RequestInfo, req *http.Request, next func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+		start := time.Now()
+		r, err := next(req)
+		var status int
+		if r != nil {
+			status = r.StatusCode
+		}
+		fn(info, status, time.Since(start), err)
+		return r, err
+	}
+}
+
+// BearerAuth returns an Interceptor that authenticates requests with a bearer
+// token obtained from token. If the server responds with 401 Unauthorized,
+// token is called again with refresh set to true, and the request is resent
+// once with the new token, provided its body can be replayed.
+func BearerAuth(token func(ctx context.Context, refresh bool) (string, error)) Interceptor {
+	return func(info RequestInfo, req *http.Request, next func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+		ctx := req.Context()
+		t, err := token(ctx, false)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+t)
+		r, err := next(req)
+		if err != nil || r.StatusCode != http.StatusUnauthorized {
+			return r, err
+		} else if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+			return r, nil // body cannot be replayed
+		}
+		drainAndClose(r.Body)
+		if t, err = token(ctx, true); err != nil {
+			return nil, err
+		}
+		req = req.Clone(ctx)
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+		req.Header.Set("Authorization", "Bearer "+t)
+		return next(req)
+	}
+}
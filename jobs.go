@@ -0,0 +1,320 @@
+package jape
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"lukechampine.com/frand"
+)
+
+// A JobState is the state of a job.
+type JobState string
+
+// Possible job states.
+const (
+	JobPending   JobState = "pending"
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+	JobCanceled  JobState = "canceled"
+)
+
+// Done reports whether s is a terminal state.
+func (s JobState) Done() bool {
+	return s == JobSucceeded || s == JobFailed || s == JobCanceled
+}
+
+// A JobStatus describes the state of a job.
+type JobStatus struct {
+	ID       string          `json:"id"`
+	State    JobState        `json:"state"`
+	Progress float64         `json:"progress"`
+	Error    string          `json:"error,omitempty"`
+	Result   json.RawMessage `json:"result,omitempty"`
+	Created  time.Time       `json:"created"`
+	Finished time.Time       `json:"finished,omitzero"`
+}
+
+// A JobFunc performs the work of a job. It should return promptly when ctx is
+// canceled. progress may be called to report the fraction of work completed,
+// from 0 to 1. If the JobFunc succeeds, its result is marshalled as JSON and
+// stored in the job's status.
+type JobFunc func(ctx context.Context, progress func(float64)) (any, error)
+
+type job struct {
+	status JobStatus
+	cancel context.CancelFunc
+	index  IndexNotifier
+}
+
+// A JobManager runs long-running operations in the background, allowing
+// handlers to respond immediately with a job ID that clients can use to track
+// the operation.
+type JobManager struct {
+	retention time.Duration
+	sem       chan struct{}
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+// NewJobManager returns a JobManager that runs at most concurrency jobs at
+// once; additional jobs remain pending until a slot is available. Finished
+// jobs are retained for the specified duration before being discarded.
+func NewJobManager(concurrency int, retention time.Duration) *JobManager {
+	if concurrency < 1 {
+		panic("concurrency must be positive")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &JobManager{
+		retention: retention,
+		sem:       make(chan struct{}, concurrency),
+		ctx:       ctx,
+		cancel:    cancel,
+		jobs:      make(map[string]*job),
+	}
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		t := time.NewTicker(max(retention, 100*time.Millisecond))
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				m.mu.Lock()
+				m.prune()
+				m.mu.Unlock()
+			}
+		}
+	}()
+	return m
+}
+
+// update modifies the status of j and wakes any blocked status queries.
+func (m *JobManager) update(j *job, fn func(*JobStatus)) {
+	m.mu.Lock()
+	fn(&j.status)
+	m.mu.Unlock()
+	j.index.Increment()
+}
+
+func (m *JobManager) run(j *job, ctx context.Context, fn JobFunc) {
+	defer m.wg.Done()
+	defer j.cancel()
+	select {
+	case m.sem <- struct{}{}:
+		defer func() { <-m.sem }()
+	case <-ctx.Done():
+		m.update(j, func(s *JobStatus) {
+			s.State = JobCanceled
+			s.Finished = time.Now()
+		})
+		return
+	}
+	m.update(j, func(s *JobStatus) { s.State = JobRunning })
+
+	res, err := fn(ctx, func(p float64) {
+		m.update(j, func(s *JobStatus) { s.Progress = min(max(p, 0), 1) })
+	})
+	var js []byte
+	if err == nil && res != nil {
+		if js, err = json.Marshal(res); err != nil {
+			err = fmt.Errorf("couldn't encode job result (%T): %w", res, err)
+		}
+	}
+	m.update(j, func(s *JobStatus) {
+		s.Finished = time.Now()
+		switch {
+		case err == nil:
+			s.State = JobSucceeded
+			s.Progress = 1
+			s.Result = js
+		case ctx.Err() != nil:
+			s.State = JobCanceled
+		default:
+			s.State = JobFailed
+			s.Error = err.Error()
+		}
+	})
+}
+
+// prune discards finished jobs older than the retention period. The caller
+// must hold m.mu.
+func (m *JobManager) prune() {
+	for id, j := range m.jobs {
+		if j.status.State.Done() && time.Since(j.status.Finished) > m.retention {
+			delete(m.jobs, id)
+		}
+	}
+}
+
+// Start starts a job that runs fn and returns its initial status.
+func (m *JobManager) Start(fn JobFunc) JobStatus {
+	ctx, cancel := context.WithCancel(m.ctx)
+	j := &job{
+		status: JobStatus{
+			ID:      hex.EncodeToString(frand.Bytes(16)),
+			State:   JobPending,
+			Created: time.Now(),
+		},
+		cancel: cancel,
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobs[j.status.ID] = j
+	m.wg.Add(1)
+	go m.run(j, ctx, fn)
+	return j.status
+}
+
+// Status returns the status of the job with the specified ID.
+func (m *JobManager) Status(id string) (JobStatus, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return JobStatus{}, false
+	}
+	return j.status, true
+}
+
+// Cancel cancels the job with the specified ID. It reports whether the job
+// exists.
+func (m *JobManager) Cancel(id string) bool {
+	m.mu.Lock()
+	j, ok := m.jobs[id]
+	m.mu.Unlock()
+	if ok {
+		j.cancel()
+	}
+	return ok
+}
+
+// Close cancels all jobs and waits for them to return. Finished jobs are no
+// longer discarded after Close is called.
+func (m *JobManager) Close() {
+	m.cancel()
+	m.wg.Wait()
+}
+
+func (m *JobManager) lookup(c Context) (*job, error) {
+	m.mu.Lock()
+	j, ok := m.jobs[c.PathParam("id")]
+	m.mu.Unlock()
+	if !ok {
+		return nil, c.Error(errors.New("job not found"), http.StatusNotFound)
+	}
+	return j, nil
+}
+
+// Routes returns the standard job routes, rooted at prefix:
+//
+//	GET    prefix/:id         returns the job's JobStatus; supports blocking
+//	                          queries (see Context.WaitIndex)
+//	GET    prefix/:id/events  streams the job's JobStatus as Server-Sent
+//	                          Events until it finishes
+//	DELETE prefix/:id         cancels the job
+//
+// The routes should be merged with the rest of the API's routes before
+// calling Mux.
+func (m *JobManager) Routes(prefix string) map[string]Handler {
+	return map[string]Handler{
+		"GET " + prefix + "/:id": func(c Context) {
+			j, err := m.lookup(c)
+			if err != nil || c.WaitIndex(&j.index) != nil {
+				return
+			}
+			m.mu.Lock()
+			status := j.status
+			m.mu.Unlock()
+			c.Encode(status)
+		},
+		"GET " + prefix + "/:id/events": func(c Context) {
+			j, err := m.lookup(c)
+			if err != nil {
+				return
+			}
+			es := c.Events(30 * time.Second)
+			defer es.Close()
+			var index uint64
+			for {
+				m.mu.Lock()
+				status := j.status
+				m.mu.Unlock()
+				if es.Send("status", strconv.FormatUint(index, 10), status) != nil || status.State.Done() {
+					return
+				}
+				if index, err = j.index.Wait(c.Request.Context(), index); err != nil {
+					return
+				}
+			}
+		},
+		"DELETE " + prefix + "/:id": func(c Context) {
+			j, err := m.lookup(c)
+			if err != nil {
+				return
+			}
+			j.cancel()
+			c.Encode(nil)
+		},
+	}
+}
+
+// StartJob starts a job that runs fn and writes its initial status with status
+// code 202. Clients can track the job via the routes returned by
+// JobManager.Routes.
+func (c Context) StartJob(m *JobManager, fn JobFunc) {
+	c.Accepted(m.Start(fn))
+}
+
+// WaitJob waits for the job at route, which must be served by
+// JobManager.Routes, to finish, and decodes its result into T. If progress is
+// non-nil, it is called with each new status of the job. If the job fails or is
+// canceled, WaitJob returns an error. Transient errors, such as network errors
+// and 5xx responses, are retried; other errors, such as the job not being
+// found, are returned.
+func WaitJob[T any](ctx context.Context, c *Client, route string, progress func(JobStatus), opts ...RequestOption) (T, error) {
+	var zero T
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var meta ResponseMeta
+	opts = append(opts, CaptureResponse(&meta))
+	for status, err := range Watch[JobStatus](ctx, c, route, 0, opts...) {
+		if err != nil {
+			if s := meta.StatusCode; s >= 400 && s < 500 && s != http.StatusRequestTimeout && s != http.StatusTooManyRequests {
+				return zero, err
+			}
+			meta = ResponseMeta{} // Watch will retry
+			continue
+		} else if progress != nil {
+			progress(status)
+		}
+		switch status.State {
+		case JobSucceeded:
+			var v T
+			if len(status.Result) == 0 {
+				return v, nil
+			} else if err := json.Unmarshal(status.Result, &v); err != nil {
+				return zero, fmt.Errorf("couldn't decode job result: %w", err)
+			}
+			return v, nil
+		case JobFailed:
+			return zero, errors.New(status.Error)
+		case JobCanceled:
+			return zero, errors.New("job canceled")
+		}
+	}
+	return zero, ctx.Err()
+}
@@ -0,0 +1,142 @@
+package jape
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// PageParams are the standard pagination parameters, sent as the "limit",
+// "offset", and "cursor" form values. Routes may paginate by offset or by an
+// opaque cursor, but a single request may not specify both.
+type PageParams struct {
+	Limit  int
+	Offset int
+	Cursor string
+}
+
+// A Page is a page of results with an embedded link to the next page. It is an
+// alternative to responding with EncodePage, for APIs that prefer to keep
+// pagination metadata in the response body.
+type Page[T any] struct {
+	Items []T `json:"items"`
+	// Next is the query string of the next page, as returned by
+	// Context.NextPage. If empty, there are no more pages.
+	Next string `json:"next,omitempty"`
+}
+
+// DecodePage decodes the standard pagination parameters into p. If the
+// request does not specify a limit, or specifies a limit greater than
+// maxLimit, maxLimit is used. If the parameters are invalid, DecodePage writes
+// an error to the response body and returns it.
+func (c Context) DecodePage(p *PageParams, maxLimit int) error {
+	*p = PageParams{Limit: maxLimit}
+	if err := c.DecodeForm("limit", &p.Limit); err != nil {
+		return err
+	} else if err := c.DecodeForm("offset", &p.Offset); err != nil {
+		return err
+	} else if err := c.DecodeForm("cursor", &p.Cursor); err != nil {
+		return err
+	} else if p.Limit < 1 {
+		return c.Error(fmt.Errorf("invalid form value %q: must be positive", "limit"), http.StatusBadRequest)
+	} else if p.Offset < 0 {
+		return c.Error(fmt.Errorf("invalid form value %q: must not be negative", "offset"), http.StatusBadRequest)
+	} else if p.Offset != 0 && p.Cursor != "" {
+		return c.Error(errors.New("cannot specify both offset and cursor"), http.StatusBadRequest)
+	}
+	p.Limit = min(p.Limit, maxLimit)
+	return nil
+}
+
+// NextPage returns the query string of the page described by next, preserving
+// any other query parameters in the request.
+func (c Context) NextPage(next PageParams) string {
+	q := c.Request.URL.Query()
+	q.Del("offset")
+	q.Del("cursor")
+	q.Set("limit", strconv.Itoa(next.Limit))
+	if next.Offset != 0 {
+		q.Set("offset", strconv.Itoa(next.Offset))
+	}
+	if next.Cursor != "" {
+		q.Set("cursor", next.Cursor)
+	}
+	return q.Encode()
+}
+
+// EncodePage writes items to the response body, like Encode. If next is
+// non-nil, a Link header pointing to the next page is also written.
+func (c Context) EncodePage(items any, next *PageParams) {
+	if next != nil {
+		c.ResponseWriter.Header().Set("Link", fmt.Sprintf(`<?%v>; rel="next"`, c.NextPage(*next)))
+	}
+	c.Encode(items)
+}
+
+// nextLink returns the query string of the next page from a Link header, if
+// present.
+func nextLink(header string) (string, bool) {
+	for _, link := range strings.Split(header, ",") {
+		target, params, ok := strings.Cut(strings.TrimSpace(link), ";")
+		if !ok || !strings.Contains(strings.ReplaceAll(params, " ", ""), `rel="next"`) {
+			continue
+		}
+		target = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(target), "<"), ">")
+		if u, err := url.Parse(target); err == nil {
+			return u.RawQuery, true
+		}
+	}
+	return "", false
+}
+
+// Paginate performs a series of GET requests to a route that calls
+// Context.DecodePage, returning an iterator over the items of each page. The
+// route may respond with either EncodePage or a Page[T]. Pages are requested
+// lazily, as iteration proceeds. If an error occurs, it is yielded as the
+// final element.
+func Paginate[T any](ctx context.Context, c *Client, route string, opts ...RequestOption) iter.Seq2[T, error] {
+	path, _, _ := strings.Cut(route, "?")
+	return func(yield func(T, error) bool) {
+		var zero T
+		for route := route; route != ""; {
+			var link string
+			var body json.RawMessage
+			if err := c.GET(ctx, route, &body, append([]RequestOption{WithHeader("Accept", JSONCodec.ContentType()), ReadHeader("Link", &link)}, opts...)...); err != nil {
+				yield(zero, err)
+				return
+			}
+
+			var page Page[T]
+			var next string
+			var hasNext bool
+			if trimmed := strings.TrimSpace(string(body)); strings.HasPrefix(trimmed, "{") {
+				if err := json.Unmarshal(body, &page); err != nil {
+					yield(zero, fmt.Errorf("couldn't decode page: %w", err))
+					return
+				}
+				next, hasNext = page.Next, page.Next != ""
+			} else {
+				if err := json.Unmarshal(body, &page.Items); err != nil {
+					yield(zero, fmt.Errorf("couldn't decode page: %w", err))
+					return
+				}
+				next, hasNext = nextLink(link)
+			}
+			for _, v := range page.Items {
+				if !yield(v, nil) {
+					return
+				}
+			}
+			route = ""
+			if hasNext && len(page.Items) > 0 {
+				route = path + "?" + next
+			}
+		}
+	}
+}
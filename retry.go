@@ -0,0 +1,157 @@
+package jape
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// A RetryPolicy controls how a Client retries requests that fail due to
+// transient errors: network errors, and responses with status code 429, 502,
+// 503, or 504.
+//
+// Only idempotent requests are retried. GET, HEAD, OPTIONS, PUT, and DELETE
+// requests are considered idempotent; POST and PATCH requests are only
+// considered idempotent if they carry an Idempotency-Key header, which the
+// Client generates automatically when Retry is set (see
+// Client.IdempotencyKeys). Requests whose bodies cannot be replayed, such as
+// those made by POSTStream, are never retried.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the first. If
+	// zero, 4 attempts are made.
+	MaxAttempts int
+	// MinBackoff and MaxBackoff bound the delay between attempts, which
+	// doubles after each attempt, with random jitter. If zero, they default to
+	// 100 milliseconds and 10 seconds, respectively. If the server sends a
+	// Retry-After header, the delay is at least as long as it requests; if it
+	// requests a delay longer than MaxBackoff, the request is not retried.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// MaxElapsed, if non-zero, limits the total time spent on a request,
+	// including all retries. A retry is never attempted if it would begin
+	// after MaxElapsed has passed, or after the request's context expires.
+	MaxElapsed time.Duration
+}
+
+func (p *RetryPolicy) maxAttempts() int {
+	if p.MaxAttempts > 0 {
+		return p.MaxAttempts
+	}
+	return 4
+}
+
+func (p *RetryPolicy) maxBackoff() time.Duration {
+	if p.MaxBackoff > 0 {
+		return p.MaxBackoff
+	}
+	return 10 * time.Second
+}
+
+// backoff returns the delay before the specified attempt (starting from 2).
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	lo, hi := p.MinBackoff, p.maxBackoff()
+	if lo <= 0 {
+		lo = 100 * time.Millisecond
+	}
+	d := hi
+	if shift := attempt - 2; shift < 32 && lo<<shift < hi {
+		d = lo << shift
+	}
+	// equal jitter: somewhere between d/2 and d
+	return d/2 + rand.N(d/2+1)
+}
+
+// retryAfter parses the Retry-After header of r, which may be either a number
+// of seconds or an HTTP date.
+func retryAfter(r *http.Response) (time.Duration, bool) {
+	v := r.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	} else if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	} else if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
+
+// retryable reports whether req may be retried.
+func retryable(req *http.Request) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false // body cannot be replayed
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	case http.MethodPost, http.MethodPatch:
+		return req.Header.Get(IdempotencyKeyHeader) != ""
+	}
+	return false
+}
+
+// transient reports whether the result of a request indicates a transient
+// failure.
+func transient(r *http.Response, err error) bool {
+	if err != nil {
+		var coe *CircuitOpenError
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) && !errors.As(err, &coe)
+	}
+	switch r.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// do sends req with send, retrying according to the policy.
+func (p *RetryPolicy) do(send func(*http.Request) (*http.Response, error), req *http.Request) (*http.Response, error) {
+	if !retryable(req) {
+		return send(req)
+	}
+	ctx := req.Context()
+	var budget time.Time
+	if p.MaxElapsed > 0 {
+		budget = time.Now().Add(p.MaxElapsed)
+	}
+	if deadline, ok := ctx.Deadline(); ok && (budget.IsZero() || deadline.Before(budget)) {
+		budget = deadline
+	}
+	for attempt := 1; ; attempt++ {
+		r, err := send(req)
+		if attempt >= p.maxAttempts() || !transient(r, err) {
+			return r, err
+		}
+		wait := p.backoff(attempt + 1)
+		if r != nil {
+			if ra, ok := retryAfter(r); ok && ra > p.maxBackoff() {
+				return r, err // the server wants us to wait too long
+			} else if ok {
+				wait = max(wait, ra)
+			}
+		}
+		if !budget.IsZero() && time.Now().Add(wait).After(budget) {
+			return r, err // no time for another attempt
+		}
+		if r != nil {
+			drainAndClose(r.Body)
+		}
+
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+
+		req = req.Clone(ctx)
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
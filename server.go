@@ -2,9 +2,9 @@ package jape
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"reflect"
 	"strconv"
@@ -18,6 +18,15 @@ type Context struct {
 	ResponseWriter http.ResponseWriter
 	Request        *http.Request
 	PathParams     httprouter.Params
+
+	cfg *muxConfig
+}
+
+// reportError passes err to the Mux's error hook, if one is set.
+func (c Context) reportError(err error) {
+	if c.cfg != nil && c.cfg.onError != nil {
+		c.cfg.onError(c.Request, err)
+	}
 }
 
 // Error writes err to the response body and returns it.
@@ -38,32 +47,99 @@ func (c Context) Check(msg string, err error) error {
 
 // Encode writes the encoding of v to the response body. If v implements the
 // ResponseWriter interface, it is written directly.
-// Otherwise, it is marshalled as JSON.
+// Otherwise, it is marshalled as JSON, or with the Codec requested by the
+// client's Accept header (see WithCodecs). If v is nil, the response has status
+// code 204; otherwise, it has status code 200.
 func (c Context) Encode(v any) {
+	if v == nil {
+		c.EncodeStatus(http.StatusNoContent, nil)
+		return
+	}
+	c.EncodeStatus(http.StatusOK, v)
+}
+
+// EncodeStatus is like Encode, but writes the specified status code, which
+// should be a 2xx code. If v is nil, only the status code is written.
+//
+// If v cannot be marshalled, EncodeStatus instead writes an error with status
+// code 500 and reports it to the Mux's error hook. If the response was already
+// being streamed (see WithStreamingThreshold), the error is only reported, and
+// the client receives a truncated body.
+func (c Context) EncodeStatus(status int, v any) {
 	switch v := v.(type) {
 	case nil:
-		c.ResponseWriter.WriteHeader(http.StatusNoContent)
+		c.ResponseWriter.WriteHeader(status)
 	default:
-		var js []byte
-		// encode nil slices as [] and nil maps as {} (instead of null)
-		if val := reflect.ValueOf(v); val.Kind() == reflect.Slice && val.Len() == 0 {
-			js = []byte("[]\n")
-		} else if val.Kind() == reflect.Map && val.Len() == 0 {
-			js = []byte("{}\n")
-		} else {
-			js, _ = json.MarshalIndent(v, "", "  ")
+		if codec := c.responseCodec(); codec != nil {
+			c.encodeCodec(codec, status, v)
+			return
 		}
-		c.ResponseWriter.Header().Set("Content-Type", "application/json")
-		c.ResponseWriter.Header().Set("Content-Length", strconv.Itoa(len(js)))
-		c.ResponseWriter.Write(js)
+		e := newResponseEncoder(c.ResponseWriter, status, c.cfg)
+		if err := e.encode(v); err != nil {
+			e.release()
+			err = fmt.Errorf("couldn't encode response type (%T): %w", v, err)
+			c.reportError(err)
+			if !e.streaming {
+				http.Error(c.ResponseWriter, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+		e.finish()
+	}
+}
+
+// responseCodec returns the non-JSON codec requested by the client, if any.
+// If the Mux has codecs other than JSON, it adds Accept to the response's Vary
+// header, since the response encoding depends on it.
+func (c Context) responseCodec() Codec {
+	if c.cfg == nil || len(c.cfg.codecs) == 0 {
+		return nil
+	}
+	c.ResponseWriter.Header().Add("Vary", "Accept")
+	return negotiateCodec(c.Request.Header.Get("Accept"), c.cfg.codecs)
+}
+
+func (c Context) encodeCodec(codec Codec, status int, v any) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if err := codec.Encode(buf, v); err != nil {
+		err = fmt.Errorf("couldn't encode response type (%T): %w", v, err)
+		c.reportError(err)
+		http.Error(c.ResponseWriter, err.Error(), http.StatusInternalServerError)
+		return
 	}
+	c.ResponseWriter.Header().Set("Content-Type", codec.ContentType())
+	c.ResponseWriter.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	c.ResponseWriter.WriteHeader(status)
+	c.ResponseWriter.Write(buf.Bytes())
+}
+
+// Created sets the Location header to location and writes v with status code
+// 201. If v is nil, only the status code is written.
+func (c Context) Created(location string, v any) {
+	c.ResponseWriter.Header().Set("Location", location)
+	c.EncodeStatus(http.StatusCreated, v)
+}
+
+// Accepted writes v with status code 202, indicating that the request will be
+// processed asynchronously. If v is nil, only the status code is written.
+func (c Context) Accepted(v any) {
+	c.EncodeStatus(http.StatusAccepted, v)
 }
 
 // DecodeLimit decodes the JSON of the request body into v. If v is larger than `n`, decoding will fail. If decoding fails, Decode
-// writes an error to the response body and returns it.
+// writes an error to the response body and returns it. If the request's
+// Content-Type matches a Codec registered with WithCodecs, that codec is used
+// instead of JSON.
 func (c Context) DecodeLimit(v any, n int64) error {
+	codec := JSONCodec
+	if c.cfg != nil {
+		if rc := codecFor(c.Request.Header.Get("Content-Type"), c.cfg.codecs); rc != nil {
+			codec = rc
+		}
+	}
 	c.Request.Body = http.MaxBytesReader(c.ResponseWriter, c.Request.Body, n)
-	if err := json.NewDecoder(c.Request.Body).Decode(v); err != nil {
+	if err := codec.Decode(c.Request.Body, v); err != nil {
 		var tooLargeErr *http.MaxBytesError
 		if errors.As(err, &tooLargeErr) {
 			return c.Error(errors.New("request body too large"), http.StatusRequestEntityTooLarge)
@@ -87,6 +163,37 @@ func (c Context) PathParam(param string) string {
 	return c.PathParams.ByName(param)
 }
 
+func decodeString(s string, v any) (err error) {
+	switch v := v.(type) {
+	case interface{ UnmarshalText([]byte) error }:
+		err = v.UnmarshalText([]byte(s))
+	case interface{ LoadString(string) error }:
+		err = v.LoadString(s)
+	case *string:
+		*v = s
+	case *int:
+		*v, err = strconv.Atoi(s)
+	case *int64:
+		*v, err = strconv.ParseInt(s, 10, 64)
+	case *uint64:
+		*v, err = strconv.ParseUint(s, 10, 64)
+	case *bool:
+		*v, err = strconv.ParseBool(s)
+	default:
+		panic(fmt.Sprintf("unsupported type %T", v))
+	}
+	return
+}
+
+func encodeString(v any) string {
+	if tm, ok := v.(interface{ MarshalText() ([]byte, error) }); ok {
+		if b, err := tm.MarshalText(); err == nil {
+			return string(b)
+		}
+	}
+	return fmt.Sprint(v)
+}
+
 // DecodeParam decodes the specified path parameter into v, which must implement
 // one of the following methods:
 //
@@ -102,31 +209,32 @@ func (c Context) PathParam(param string) string {
 // If decoding fails, DecodeParam writes an error to the response body and
 // returns it.
 func (c Context) DecodeParam(param string, v any) error {
-	var err error
-	switch v := v.(type) {
-	case interface{ UnmarshalText([]byte) error }:
-		err = v.UnmarshalText([]byte(c.PathParam(param)))
-	case interface{ LoadString(string) error }:
-		err = v.LoadString(c.PathParam(param))
-	case *string:
-		*v = c.PathParam(param)
-	case *int:
-		*v, err = strconv.Atoi(c.PathParam(param))
-	case *int64:
-		*v, err = strconv.ParseInt(c.PathParam(param), 10, 64)
-	case *uint64:
-		*v, err = strconv.ParseUint(c.PathParam(param), 10, 64)
-	case *bool:
-		*v, err = strconv.ParseBool(c.PathParam(param))
-	default:
-		panic("unsupported type")
-	}
-	if err != nil {
+	if err := decodeString(c.PathParam(param), v); err != nil {
 		return c.Error(fmt.Errorf("couldn't parse param %q: %w", param, err), http.StatusBadRequest)
 	}
 	return nil
 }
 
+// formValue returns the first form value with the specified key, and whether
+// the key was present in the request at all.
+func (c Context) formValue(key string) (string, bool) {
+	if c.Request.Form == nil {
+		c.Request.ParseMultipartForm(32 << 20) // same default as (*http.Request).FormValue
+	}
+	vs, ok := c.Request.Form[key]
+	if !ok || len(vs) == 0 {
+		return "", ok
+	}
+	return vs[0], true
+}
+
+// HasForm reports whether the request contains a form value with the specified
+// key, even if that value is empty.
+func (c Context) HasForm(key string) bool {
+	_, ok := c.formValue(key)
+	return ok
+}
+
 // DecodeForm decodes the form value with the specified key into v, which must
 // implement one of the following methods:
 //
@@ -143,35 +251,109 @@ func (c Context) DecodeParam(param string, v any) error {
 // returns it. If the form value is empty, no error is returned and v is
 // unchanged.
 func (c Context) DecodeForm(key string, v any) error {
-	value := c.Request.FormValue(key)
+	value, _ := c.formValue(key)
 	if value == "" {
 		return nil
 	}
-	var err error
-	switch v := v.(type) {
-	case interface{ UnmarshalText([]byte) error }:
-		err = v.UnmarshalText([]byte(value))
-	case interface{ LoadString(string) error }:
-		err = v.LoadString(value)
-	case *string:
-		*v = value
-	case *int:
-		*v, err = strconv.Atoi(value)
-	case *int64:
-		*v, err = strconv.ParseInt(value, 10, 64)
-	case *uint64:
-		*v, err = strconv.ParseUint(value, 10, 64)
-	case *bool:
-		*v, err = strconv.ParseBool(value)
+	if err := decodeString(value, v); err != nil {
+		return c.Error(fmt.Errorf("invalid form value %q: %w", key, err), http.StatusBadRequest)
+	}
+	return nil
+}
+
+// DecodeFormRequired is like DecodeForm, but if the form value is missing or
+// empty, it writes an error (with status code 400) to the response body and
+// returns it.
+func (c Context) DecodeFormRequired(key string, v any) error {
+	if value, _ := c.formValue(key); value == "" {
+		return c.Error(fmt.Errorf("missing required form value %q", key), http.StatusBadRequest)
+	}
+	return c.DecodeForm(key, v)
+}
+
+// DecodeFormDefault is like DecodeForm, but if the form value is missing or
+// empty, v is set to def. The type of def must be assignable to the type that v
+// points to, or both must be numeric types, in which case def is converted;
+// if def is nil, v is set to its zero value. Otherwise, DecodeFormDefault
+// writes an error (with status code 500) to the response body and returns it.
+func (c Context) DecodeFormDefault(key string, v, def any) error {
+	if value, _ := c.formValue(key); value != "" {
+		return c.DecodeForm(key, v)
+	}
+	dst := reflect.ValueOf(v)
+	if dst.Kind() != reflect.Pointer || dst.IsNil() {
+		return c.Error(fmt.Errorf("DecodeFormDefault called on non-pointer type %T", v), http.StatusInternalServerError)
+	} else if err := setDefault(dst.Elem(), def); err != nil {
+		return c.Error(fmt.Errorf("invalid default for form value %q: %w", key, err), http.StatusInternalServerError)
+	}
+	return nil
+}
+
+// setDefault sets v to def, converting numeric types if necessary.
+func setDefault(v reflect.Value, def any) error {
+	if def == nil {
+		v.SetZero()
+		return nil
+	}
+	dv := reflect.ValueOf(def)
+	if dv.Type().AssignableTo(v.Type()) {
+		v.Set(dv)
+		return nil
+	}
+	var overflow bool
+	switch {
+	case dv.CanInt() && v.CanInt():
+		overflow = v.OverflowInt(dv.Int())
+	case dv.CanInt() && v.CanUint():
+		overflow = dv.Int() < 0 || v.OverflowUint(uint64(dv.Int()))
+	case dv.CanUint() && v.CanUint():
+		overflow = v.OverflowUint(dv.Uint())
+	case dv.CanUint() && v.CanInt():
+		overflow = dv.Uint() > math.MaxInt64 || v.OverflowInt(int64(dv.Uint()))
+	case (dv.CanInt() || dv.CanUint() || dv.CanFloat()) && v.CanFloat():
 	default:
-		panic(fmt.Sprintf("unsupported type %T", v))
+		return fmt.Errorf("%T is not assignable to %v", def, v.Type())
 	}
-	if err != nil {
-		return c.Error(fmt.Errorf("invalid form value %q: %w", key, err), http.StatusBadRequest)
+	if overflow {
+		return fmt.Errorf("%v overflows %v", def, v.Type())
+	}
+	v.Set(dv.Convert(v.Type()))
+	return nil
+}
+
+// DecodeHeader decodes the request header with the specified name into v,
+// which must implement one of the following methods:
+//
+//	UnmarshalText([]byte) error
+//	LoadString(string) error
+//
+// The following basic types are also supported:
+//
+//	*int
+//	*bool
+//	*string
+//
+// If decoding fails, DecodeHeader writes an error to the response body and
+// returns it. If the header is absent, no error is returned and v is
+// unchanged.
+func (c Context) DecodeHeader(name string, v any) error {
+	value := c.Request.Header.Get(name)
+	if value == "" {
+		return nil
+	}
+	if err := decodeString(value, v); err != nil {
+		return c.Error(fmt.Errorf("invalid header %q: %w", name, err), http.StatusBadRequest)
 	}
 	return nil
 }
 
+// SetHeader sets the response header with the specified name to the encoding
+// of v. If v implements MarshalText, it is used; otherwise, v is formatted with
+// fmt.Sprint. SetHeader must be called before the response body is written.
+func (c Context) SetHeader(name string, v any) {
+	c.ResponseWriter.Header().Set(name, encodeString(v))
+}
+
 // Custom is a no-op that simply declares the request and response types used by
 // a handler. This allows japecheck to be used on endpoints that do not speak
 // JSON.
@@ -180,16 +362,90 @@ func (c Context) Custom(any, any) {}
 // A Handler handles HTTP requests.
 type Handler func(Context)
 
-func adaptor(h Handler) httprouter.Handle {
+type muxConfig struct {
+	onError         func(*http.Request, error)
+	compactJSON     bool
+	streamThreshold int
+	codecs          []Codec
+
+	batchPath        string
+	batchParallelism int
+
+	noImplicitHEAD bool
+}
+
+// A MuxOption configures the behavior of a Mux.
+type MuxOption func(*muxConfig)
+
+// WithErrorHook sets a function that is called whenever a handler fails to
+// write its response, e.g. because the response could not be marshalled.
+func WithErrorHook(fn func(req *http.Request, err error)) MuxOption {
+	return func(cfg *muxConfig) {
+		cfg.onError = fn
+	}
+}
+
+// WithCompactJSON causes responses to be encoded without indentation.
+func WithCompactJSON() MuxOption {
+	return func(cfg *muxConfig) {
+		cfg.compactJSON = true
+	}
+}
+
+// WithStreamingThreshold causes large slice responses to be streamed directly
+// to the client, using chunked encoding, instead of being buffered in their
+// entirety. Once more than n bytes of a response have been encoded, they are
+// flushed to the client, and subsequent elements are written as they are
+// encoded. Responses smaller than n bytes are written with a Content-Length
+// header, as usual.
+func WithStreamingThreshold(n int) MuxOption {
+	return func(cfg *muxConfig) {
+		cfg.streamThreshold = n
+	}
+}
+
+// WithCodecs registers additional codecs with a Mux. Request bodies are decoded
+// with the codec matching their Content-Type, and responses are encoded with
+// the codec that the request's Accept header weights most highly: by q-value,
+// then by the specificity of the matching media range, then by position in
+// the header. JSON is always supported, and is used when no other codec is
+// preferred. Responses include a Vary: Accept header.
+func WithCodecs(codecs ...Codec) MuxOption {
+	return func(cfg *muxConfig) {
+		cfg.codecs = append(cfg.codecs, codecs...)
+	}
+}
+
+// WithoutImplicitHEAD disables the handling of HEAD requests by GET routes, so
+// that only explicitly-defined HEAD routes are served.
+func WithoutImplicitHEAD() MuxOption {
+	return func(cfg *muxConfig) {
+		cfg.noImplicitHEAD = true
+	}
+}
+
+type muxConfigKey struct{}
+
+func adaptor(h Handler, cfg *muxConfig) httprouter.Handle {
 	return func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
-		h(Context{ResponseWriter: w, Request: req, PathParams: ps})
+		h(Context{ResponseWriter: w, Request: req, PathParams: ps, cfg: cfg})
 	}
 }
 
 // Mux returns an http.Handler for the provided set of routes. The map keys must
 // contain both the method and path of the route, separated by whitespace, e.g.
 // "GET /foo/:bar".
-func Mux(routes map[string]Handler) *httprouter.Router {
+//
+// Unless a HEAD route is defined explicitly for a path, HEAD requests to a GET
+// route are handled by its GET handler, with the response body omitted (see
+// WithoutImplicitHEAD). Blocking queries and streams return immediately in
+// response to HEAD requests. OPTIONS requests are answered automatically with
+// the methods allowed for the path.
+func Mux(routes map[string]Handler, opts ...MuxOption) *httprouter.Router {
+	cfg := new(muxConfig)
+	for _, opt := range opts {
+		opt(cfg)
+	}
 	router := httprouter.New()
 	for path, h := range routes {
 		fs := strings.Fields(path)
@@ -199,23 +455,51 @@ func Mux(routes map[string]Handler) *httprouter.Router {
 		method, path := fs[0], fs[1]
 		switch method {
 		case http.MethodGet:
-			router.GET(path, adaptor(h))
+			router.GET(path, adaptor(h, cfg))
 		case http.MethodPost:
-			router.POST(path, adaptor(h))
+			router.POST(path, adaptor(h, cfg))
 		case http.MethodPut:
-			router.PUT(path, adaptor(h))
+			router.PUT(path, adaptor(h, cfg))
 		case http.MethodDelete:
-			router.DELETE(path, adaptor(h))
+			router.DELETE(path, adaptor(h, cfg))
 		case http.MethodPatch:
-			router.PATCH(path, adaptor(h))
+			router.PATCH(path, adaptor(h, cfg))
 		case http.MethodHead:
-			router.HEAD(path, adaptor(h))
+			router.HEAD(path, adaptor(h, cfg))
 		case http.MethodOptions:
-			router.OPTIONS(path, adaptor(h))
+			router.OPTIONS(path, adaptor(h, cfg))
 		default:
 			panic(fmt.Sprintf("unhandled method %q", method))
 		}
 	}
+	if !cfg.noImplicitHEAD {
+		// Rather than registering a HEAD route for each GET route, which could
+		// conflict with explicit HEAD routes, fall back to the GET route when
+		// no HEAD route matches. net/http discards the body of responses to
+		// HEAD requests.
+		addHEAD := func(h http.Header) {
+			if allow := h.Get("Allow"); strings.Contains(allow, http.MethodGet) && !strings.Contains(allow, http.MethodHead) {
+				h.Set("Allow", allow+", "+http.MethodHead)
+			}
+		}
+		router.MethodNotAllowed = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.Method == http.MethodHead {
+				if h, ps, _ := router.Lookup(http.MethodGet, req.URL.Path); h != nil {
+					w.Header().Del("Allow")
+					h(w, req, ps)
+					return
+				}
+			}
+			addHEAD(w.Header())
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		})
+		router.GlobalOPTIONS = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			addHEAD(w.Header())
+		})
+	}
+	if cfg.batchPath != "" {
+		router.POST(cfg.batchPath, adaptor(batchHandler(router, cfg), cfg))
+	}
 	return router
 }
 
@@ -224,10 +508,13 @@ func Mux(routes map[string]Handler) *httprouter.Router {
 func Adapt(mid func(http.Handler) http.Handler) func(Handler) Handler {
 	return func(h Handler) Handler {
 		srv := mid(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-			h(Context{ResponseWriter: w, Request: req, PathParams: httprouter.ParamsFromContext(req.Context())})
+			cfg, _ := req.Context().Value(muxConfigKey{}).(*muxConfig)
+			h(Context{ResponseWriter: w, Request: req, PathParams: httprouter.ParamsFromContext(req.Context()), cfg: cfg})
 		}))
 		return func(c Context) {
-			srv.ServeHTTP(c.ResponseWriter, c.Request.WithContext(context.WithValue(c.Request.Context(), httprouter.ParamsKey, c.PathParams)))
+			ctx := context.WithValue(c.Request.Context(), httprouter.ParamsKey, c.PathParams)
+			ctx = context.WithValue(ctx, muxConfigKey{}, c.cfg)
+			srv.ServeHTTP(c.ResponseWriter, c.Request.WithContext(ctx))
 		}
 	}
 }
@@ -1,12 +1,25 @@
 package jape
 
 import (
+	"bytes"
 	"context"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
 	"net"
 	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"lukechampine.com/frand"
 )
@@ -74,3 +87,1681 @@ func TestRequestTooLarge(t *testing.T) {
 		t.Fatalf(`expected %q, got %q`, hex.EncodeToString(content), r.Bar)
 	}
 }
+
+func TestDecodeForm(t *testing.T) {
+	handler := Mux(map[string]Handler{
+		"GET /foo": func(c Context) {
+			var n int
+			var s string
+			if c.DecodeFormRequired("n", &n) != nil || c.DecodeFormDefault("s", &s, "default") != nil {
+				return
+			}
+			c.Encode(fmt.Sprintf("%d %s %v", n, s, c.HasForm("flag")))
+		},
+		"GET /defaults": func(c Context) {
+			var u uint64
+			var f float64
+			s := "nonzero"
+			if c.DecodeFormDefault("u", &u, 10) != nil || c.DecodeFormDefault("f", &f, 3) != nil || c.DecodeFormDefault("s", &s, nil) != nil {
+				return
+			}
+			c.Encode(fmt.Sprintf("%d %v %q", u, f, s))
+		},
+		"GET /overflow": func(c Context) {
+			var u uint8
+			c.DecodeFormDefault("u", &u, 300)
+		},
+		"GET /mismatch": func(c Context) {
+			var n int
+			c.DecodeFormDefault("n", &n, "10")
+		},
+	})
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/foo", http.StatusBadRequest, "missing required form value \"n\"\n"},
+		{"/foo?n=", http.StatusBadRequest, "missing required form value \"n\"\n"},
+		{"/foo?n=foo", http.StatusBadRequest, "invalid form value \"n\": strconv.Atoi: parsing \"foo\": invalid syntax\n"},
+		{"/foo?n=1", http.StatusOK, "\"1 default false\""},
+		{"/foo?n=1&s=bar&flag", http.StatusOK, "\"1 bar true\""},
+		// defaults are converted to the destination type if possible
+		{"/defaults", http.StatusOK, `"10 3 \"\""`},
+		{"/overflow", http.StatusInternalServerError, "invalid default for form value \"u\": 300 overflows uint8\n"},
+		{"/mismatch", http.StatusInternalServerError, "invalid default for form value \"n\": string is not assignable to int\n"},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.path, nil))
+		if rec.Code != test.status {
+			t.Errorf("%q: expected status %v, got %v", test.path, test.status, rec.Code)
+		} else if rec.Body.String() != test.body {
+			t.Errorf("%q: expected body %q, got %q", test.path, test.body, rec.Body.String())
+		}
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	srv := httptest.NewServer(Mux(map[string]Handler{
+		"GET /foo": func(c Context) {
+			var n int
+			if c.DecodeHeader("X-Count", &n) != nil {
+				return
+			}
+			c.SetHeader("X-Double", n*2)
+			c.Encode(n)
+		},
+	}))
+	defer srv.Close()
+	c := Client{BaseURL: srv.URL}
+
+	var n, double int
+	if err := c.GET(context.Background(), "/foo", &n, WithHeader("X-Count", 3), ReadHeader("X-Double", &double)); err != nil {
+		t.Fatal(err)
+	} else if n != 3 || double != 6 {
+		t.Fatalf("expected 3 and 6, got %v and %v", n, double)
+	}
+	if err := c.GET(context.Background(), "/foo", &n, WithHeader("X-Count", "foo")); err == nil || !strings.Contains(err.Error(), `invalid header "X-Count"`) {
+		t.Fatalf("expected invalid header error, got %v", err)
+	}
+}
+
+func TestEncodeStatus(t *testing.T) {
+	srv := httptest.NewServer(Mux(map[string]Handler{
+		"POST /foo": func(c Context) {
+			var n int
+			if c.Decode(&n) != nil {
+				return
+			}
+			c.Created(fmt.Sprintf("/foo/%d", n), n)
+		},
+	}))
+	defer srv.Close()
+	c := Client{BaseURL: srv.URL}
+
+	var n int
+	var location string
+	if err := c.POST(context.Background(), "/foo", 7, &n, ExpectStatus(http.StatusCreated), ReadHeader("Location", &location)); err != nil {
+		t.Fatal(err)
+	} else if n != 7 || location != "/foo/7" {
+		t.Fatalf("expected 7 and /foo/7, got %v and %v", n, location)
+	}
+	if err := c.POST(context.Background(), "/foo", 7, &n, ExpectStatus(http.StatusOK)); err == nil {
+		t.Fatal("expected unexpected status error")
+	}
+}
+
+func TestEncodeError(t *testing.T) {
+	var hookErr error
+	handler := Mux(map[string]Handler{
+		"GET /foo": func(c Context) { c.Encode(math.NaN()) },
+	}, WithErrorHook(func(_ *http.Request, err error) { hookErr = err }))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/foo", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status 500, got %v", rec.Code)
+	} else if hookErr == nil {
+		t.Fatal("expected error hook to be called")
+	} else if rec.Body.String() != hookErr.Error()+"\n" {
+		t.Fatalf("expected body %q, got %q", hookErr.Error()+"\n", rec.Body.String())
+	}
+}
+
+func TestEncodeStreaming(t *testing.T) {
+	type item struct {
+		ID   int               `json:"id"`
+		Tags []string          `json:"tags"`
+		Meta map[string]string `json:"meta,omitempty"`
+	}
+	items := make([]item, 1000)
+	for i := range items {
+		items[i] = item{ID: i, Tags: []string{"foo", "bar"}}
+	}
+	items[3].Meta = map[string]string{"baz": "qux"}
+
+	for _, compact := range []bool{false, true} {
+		opts := []MuxOption{WithStreamingThreshold(4096)}
+		want, _ := json.MarshalIndent(items, "", "  ")
+		if compact {
+			opts = append(opts, WithCompactJSON())
+			want, _ = json.Marshal(items)
+		}
+		handler := Mux(map[string]Handler{
+			"GET /large": func(c Context) { c.Encode(items) },
+			"GET /small": func(c Context) { c.Encode(items[:2]) },
+		}, opts...)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/large", nil))
+		if rec.Body.String() != string(want) {
+			t.Fatalf("streamed output does not match json.Marshal (compact: %v)", compact)
+		} else if rec.Header().Get("Content-Length") != "" || !rec.Flushed {
+			t.Fatal("expected large response to be streamed")
+		}
+
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/small", nil))
+		if rec.Header().Get("Content-Length") != strconv.Itoa(rec.Body.Len()) {
+			t.Fatal("expected small response to have a Content-Length")
+		}
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	type item struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	}
+	items := make([]item, 100)
+	handler := Mux(map[string]Handler{
+		"GET /items": func(c Context) { c.Encode(items) },
+	})
+	req := httptest.NewRequest(http.MethodGet, "/items", nil)
+
+	b.ReportAllocs()
+	for b.Loop() {
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+}
+
+type testBlob []byte
+
+func (b testBlob) MarshalBinary() ([]byte, error) { return append([]byte("blob:"), b...), nil }
+
+func (b *testBlob) UnmarshalBinary(p []byte) error {
+	*b = append((*b)[:0], bytes.TrimPrefix(p, []byte("blob:"))...)
+	return nil
+}
+
+func TestCodecs(t *testing.T) {
+	var contentType string
+	srv := httptest.NewServer(Mux(map[string]Handler{
+		"POST /echo": func(c Context) {
+			var b testBlob
+			if c.Decode(&b) != nil {
+				return
+			}
+			contentType = c.Request.Header.Get("Content-Type")
+			c.Encode(b)
+		},
+	}, WithCodecs(BinaryCodec)))
+	defer srv.Close()
+
+	for _, codec := range []Codec{nil, BinaryCodec} {
+		c := Client{BaseURL: srv.URL, Codec: codec}
+		var resp testBlob
+		if err := c.POST(context.Background(), "/echo", testBlob("foo"), &resp); err != nil {
+			t.Fatal(err)
+		} else if string(resp) != "foo" {
+			t.Fatalf("expected %q, got %q", "foo", resp)
+		} else if contentType != c.codec().ContentType() {
+			t.Fatalf("expected request content type %q, got %q", c.codec().ContentType(), contentType)
+		}
+	}
+
+	// responses are selected by q-value and vary by Accept
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", "application/json"},
+		{"application/octet-stream", "application/octet-stream"},
+		{"application/json, application/octet-stream", "application/json"},
+		{"application/octet-stream, application/json", "application/octet-stream"},
+		{"application/json;q=0.5, application/octet-stream", "application/octet-stream"},
+		{"application/octet-stream;q=0.2, application/json;q=0.8", "application/json"},
+		{"application/octet-stream;q=0.5, */*", "application/json"},
+		{"application/*;q=0.5, application/json;q=0.1", "application/octet-stream"},
+		{"application/octet-stream;q=0", "application/json"},
+		{"text/plain", "application/json"},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("POST", srv.URL+"/echo", strings.NewReader(`"Zm9v"`))
+		req.Header.Set("Content-Type", "application/json")
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if ct := resp.Header.Get("Content-Type"); ct != tt.want {
+			t.Errorf("Accept %q: expected %q, got %q", tt.accept, tt.want, ct)
+		} else if vary := resp.Header.Get("Vary"); vary != "Accept" {
+			t.Errorf("Accept %q: expected Vary: Accept, got %q", tt.accept, vary)
+		}
+	}
+}
+
+func TestEncodeStream(t *testing.T) {
+	var producers atomic.Int32
+	srv := httptest.NewServer(Mux(map[string]Handler{
+		"GET /seq": func(c Context) {
+			c.EncodeStream(func(yield func(int) bool) {
+				for i := range 100 {
+					if !yield(i) {
+						return
+					}
+				}
+			})
+		},
+		"GET /chan": func(c Context) {
+			ch := make(chan int)
+			go func() {
+				defer producers.Add(-1)
+				defer close(ch)
+				for i := range 100 {
+					ch <- i
+				}
+			}()
+			producers.Add(1)
+			c.EncodeStream(ch)
+		},
+		"GET /bad": func(c Context) {
+			c.EncodeStream(func(yield func(float64) bool) {
+				_ = yield(1) && yield(math.Inf(1)) && yield(2)
+			})
+		},
+		"GET /truncated": func(c Context) {
+			// declares the trailers, but ends without sending them
+			c.ResponseWriter.Header().Set("Trailer", "Stream-Status")
+			c.ResponseWriter.Write([]byte("1\n"))
+		},
+	}))
+	defer srv.Close()
+	c := &Client{BaseURL: srv.URL}
+
+	// encoding failures are reported to the client, rather than silently
+	// truncating the stream
+	var got []float64
+	var streamErr error
+	for f, err := range Stream[float64](context.Background(), c, "/bad") {
+		if err != nil {
+			streamErr = err
+			break
+		}
+		got = append(got, f)
+	}
+	if len(got) != 1 || streamErr == nil || !strings.Contains(streamErr.Error(), "couldn't encode stream element") {
+		t.Fatalf("expected encoding error after 1 element, got %v, %v", got, streamErr)
+	}
+
+	streamErr = nil
+	for _, err := range Stream[float64](context.Background(), c, "/truncated") {
+		streamErr = err
+	}
+	if streamErr == nil || streamErr.Error() != "stream ended unexpectedly" {
+		t.Fatalf("expected truncation error, got %v", streamErr)
+	}
+
+	for _, route := range []string{"/seq", "/chan"} {
+		var n int
+		for i, err := range Stream[int](context.Background(), c, route) {
+			if err != nil {
+				t.Fatal(err)
+			} else if i != n {
+				t.Fatalf("expected %v, got %v", n, i)
+			}
+			n++
+		}
+		if n != 100 {
+			t.Fatalf("expected 100 elements, got %v", n)
+		}
+	}
+
+	// channel producers should not leak if the stream ends early
+	if _, err := c.HEAD(context.Background(), "/chan"); err != nil {
+		t.Fatal(err)
+	}
+	for _, err := range Stream[int](context.Background(), c, "/chan") {
+		if err != nil {
+			t.Fatal(err)
+		}
+		break
+	}
+	for start := time.Now(); producers.Load() != 0; time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("expected producers to exit, %v still running", producers.Load())
+		}
+	}
+}
+
+func TestDecodeStream(t *testing.T) {
+	type item struct {
+		ID   int    `json:"id"`
+		Name string `json:"name,omitempty"`
+	}
+	srv := httptest.NewServer(Mux(map[string]Handler{
+		"POST /items": func(c Context) {
+			var sum int
+			var it item
+			if c.DecodeStreamLimit(&it, 100, func() error {
+				if it.Name != "" {
+					return c.Error(errors.New("element was not zeroed"), http.StatusBadRequest)
+				}
+				sum += it.ID
+				return nil
+			}) != nil {
+				return
+			}
+			c.Encode(sum)
+		},
+	}))
+	defer srv.Close()
+	c := &Client{BaseURL: srv.URL}
+
+	// NDJSON
+	var sum int
+	err := POSTStream(context.Background(), c, "/items", func(yield func(item) bool) {
+		for i := range 1000 {
+			if !yield(item{ID: i}) {
+				return
+			}
+		}
+	}, &sum)
+	if err != nil {
+		t.Fatal(err)
+	} else if sum != 999*1000/2 {
+		t.Fatalf("expected %v, got %v", 999*1000/2, sum)
+	}
+
+	// JSON array
+	if err := c.POST(context.Background(), "/items", []item{{ID: 1}, {ID: 2}}, &sum); err != nil {
+		t.Fatal(err)
+	} else if sum != 3 {
+		t.Fatalf("expected 3, got %v", sum)
+	}
+
+	// element too large
+	err = c.POST(context.Background(), "/items", []item{{ID: 1}, {ID: 2, Name: strings.Repeat("a", 100)}}, &sum)
+	if err == nil || err.Error() != "request element 1 too large" {
+		t.Fatalf(`expected "request element 1 too large", got %v`, err)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	srv := httptest.NewServer(Mux(map[string]Handler{
+		"GET /events": func(c Context) {
+			es := c.Events(time.Millisecond)
+			defer es.Close()
+			var start int
+			if id := es.LastEventID(); id != "" {
+				n, _ := strconv.Atoi(id)
+				start = n + 1
+			}
+			es.SetRetry(10 * time.Millisecond)
+			// send three events per connection, forcing the client to resume
+			for i := start; i < start+3; i++ {
+				es.Send("count", strconv.Itoa(i), i)
+				time.Sleep(2 * time.Millisecond)
+			}
+		},
+	}))
+	defer srv.Close()
+	c := &Client{BaseURL: srv.URL}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var n int
+	for e, err := range Subscribe[int](ctx, c, "/events") {
+		if err != nil {
+			t.Fatal(err)
+		} else if e.Data != n || e.ID != strconv.Itoa(n) || e.Name != "count" {
+			t.Fatalf("expected event %v, got %+v", n, e)
+		}
+		if n++; n == 10 {
+			break
+		}
+	}
+	if n != 10 {
+		t.Fatalf("expected 10 events, got %v", n)
+	}
+
+	// names and IDs cannot inject additional fields or events
+	rec := httptest.NewRecorder()
+	es := Context{ResponseWriter: rec, Request: httptest.NewRequest(http.MethodGet, "/events", nil)}.Events(0)
+	defer es.Close()
+	if err := es.Send("count\ndata: 0\n\nevent: fake", "", 1); err == nil {
+		t.Fatal("expected error for name containing newline")
+	} else if err := es.Send("count", "1\rdata: 0", 1); err == nil {
+		t.Fatal("expected error for ID containing carriage return")
+	} else if err := es.Send("count", "1", 1); err != nil {
+		t.Fatal(err)
+	} else if body := rec.Body.String(); body != "id: 1\nevent: count\ndata: 1\n\n" {
+		t.Fatalf("unexpected event stream: %q", body)
+	}
+
+	// concurrent calls to Close should not panic
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			es.Close()
+		}()
+	}
+	wg.Wait()
+}
+
+func TestWebSocket(t *testing.T) {
+	type msg struct {
+		N    int    `json:"n"`
+		Data string `json:"data"`
+	}
+	srv := httptest.NewServer(Mux(map[string]Handler{
+		"GET /echo": func(c Context) {
+			ws, err := UpgradeWebSocket[msg, msg](c)
+			if err != nil {
+				return
+			}
+			defer ws.Close()
+			ws.KeepAlive(10 * time.Millisecond)
+			for {
+				m, err := ws.Read()
+				if err != nil {
+					return
+				} else if m.N < 0 {
+					ws.CloseWithReason(CloseNormal, "done")
+					return
+				}
+				// wait long enough for several pings to be sent
+				time.Sleep(30 * time.Millisecond)
+				m.N *= 2
+				ws.Write(m)
+			}
+		},
+		"GET /denied": func(c Context) {
+			c.Error(errors.New(strings.Repeat("x", 100)), http.StatusForbidden)
+		},
+	}))
+	defer srv.Close()
+	c := &Client{BaseURL: srv.URL}
+
+	ws, err := DialWebSocket[msg, msg](context.Background(), c, "/echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	// exercise all three payload length encodings
+	for i, n := range []int{10, 1000, 100000} {
+		if err := ws.Write(msg{N: i, Data: strings.Repeat("a", n)}); err != nil {
+			t.Fatal(err)
+		}
+		if m, err := ws.Read(); err != nil {
+			t.Fatal(err)
+		} else if m.N != i*2 || len(m.Data) != n {
+			t.Fatalf("unexpected echo: %v %v", m.N, len(m.Data))
+		}
+	}
+	ws.Write(msg{N: -1})
+	var ce *CloseError
+	if _, err := ws.Read(); !errors.As(err, &ce) || ce.Code != CloseNormal || ce.Reason != "done" {
+		t.Fatalf("expected close error, got %v", err)
+	}
+
+	// non-websocket requests are rejected
+	var m msg
+	if err := c.GET(context.Background(), "/echo", &m); err == nil || err.Error() != "not a websocket handshake" {
+		t.Fatalf("expected handshake error, got %v", err)
+	}
+
+	// failed handshakes honor MaxErrorSize
+	c.MaxErrorSize = 10
+	if _, err := DialWebSocket[msg, msg](context.Background(), c, "/denied"); err == nil || err.Error() != strings.Repeat("x", 10) {
+		t.Fatalf("expected truncated error, got %v", err)
+	}
+
+	// the handshake uses the Client's HTTPClient, e.g. for custom TLS roots
+	tlsSrv := httptest.NewTLSServer(srv.Config.Handler)
+	defer tlsSrv.Close()
+	c = &Client{BaseURL: tlsSrv.URL, HTTPClient: tlsSrv.Client()}
+	ws, err = DialWebSocket[msg, msg](context.Background(), c, "/echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	ws.KeepAlive(10 * time.Millisecond)
+	if err := ws.Write(msg{N: 3}); err != nil {
+		t.Fatal(err)
+	} else if m, err := ws.Read(); err != nil {
+		t.Fatal(err)
+	} else if m.N != 6 {
+		t.Fatalf("unexpected echo: %v", m.N)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	var idx IndexNotifier
+	var mu sync.Mutex
+	var value int
+	srv := httptest.NewServer(Mux(map[string]Handler{
+		"GET /value": func(c Context) {
+			if c.WaitIndex(&idx) != nil {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			c.Encode(value)
+		},
+	}))
+	defer srv.Close()
+	c := &Client{BaseURL: srv.URL}
+
+	go func() {
+		for i := 1; i <= 5; i++ {
+			time.Sleep(10 * time.Millisecond)
+			mu.Lock()
+			value = i
+			mu.Unlock()
+			idx.Increment()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	last := -1
+	for v, err := range Watch[int](ctx, c, "/value", 50*time.Millisecond) {
+		if err != nil {
+			t.Fatal(err)
+		} else if v < last {
+			t.Fatalf("expected value of at least %v, got %v", last, v)
+		}
+		last = v
+		if v == 5 {
+			break
+		}
+	}
+	if last != 5 {
+		t.Fatalf("expected to observe final value, got %v", last)
+	}
+
+	// the client's timeout should not cut blocking queries short
+	c.Timeout = 20 * time.Millisecond
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		mu.Lock()
+		value = 6
+		mu.Unlock()
+		idx.Increment()
+	}()
+	for v, err := range Watch[int](ctx, c, "/value", time.Second) {
+		if err != nil {
+			t.Fatal(err)
+		} else if v == 6 {
+			break
+		}
+	}
+}
+
+func TestJobs(t *testing.T) {
+	jm := NewJobManager(1, time.Minute)
+	defer jm.Close()
+	release := make(chan struct{})
+	routes := jm.Routes("/jobs")
+	routes["POST /scan"] = func(c Context) {
+		c.StartJob(jm, func(ctx context.Context, progress func(float64)) (any, error) {
+			progress(0.5)
+			select {
+			case <-release:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			return 42, nil
+		})
+	}
+	routes["POST /hang"] = func(c Context) {
+		c.StartJob(jm, func(ctx context.Context, _ func(float64)) (any, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+	}
+	// allow status queries to fail, to check that WaitJob retries
+	var failNext atomic.Bool
+	status := routes["GET /jobs/:id"]
+	routes["GET /jobs/:id"] = func(c Context) {
+		if failNext.Swap(false) {
+			c.Error(errors.New("unavailable"), http.StatusServiceUnavailable)
+			return
+		}
+		status(c)
+	}
+	srv := httptest.NewServer(Mux(routes))
+	defer srv.Close()
+	c := &Client{BaseURL: srv.URL}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var first, second JobStatus
+	if err := c.POST(ctx, "/scan", nil, &first, ExpectStatus(http.StatusAccepted)); err != nil {
+		t.Fatal(err)
+	} else if err := c.POST(ctx, "/scan", nil, &second); err != nil {
+		t.Fatal(err)
+	}
+
+	// the second job should remain pending until the first finishes
+	var sawProgress bool
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		if st, _ := jm.Status(second.ID); st.State != JobPending {
+			t.Errorf("expected second job to be pending, got %v", st.State)
+		}
+		close(release)
+	}()
+	res, err := WaitJob[int](ctx, c, "/jobs/"+first.ID, func(s JobStatus) {
+		sawProgress = sawProgress || s.Progress == 0.5
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if res != 42 {
+		t.Fatalf("expected 42, got %v", res)
+	} else if !sawProgress {
+		t.Fatal("expected to observe progress")
+	}
+
+	// cancel a job
+	var third JobStatus
+	if err := c.POST(ctx, "/hang", nil, &third); err != nil {
+		t.Fatal(err)
+	}
+	failNext.Store(true)
+	if err := c.DELETE(ctx, "/jobs/"+third.ID); err != nil {
+		t.Fatal(err)
+	} else if _, err := WaitJob[int](ctx, c, "/jobs/"+third.ID, nil); err == nil || err.Error() != "job canceled" {
+		t.Fatalf("expected cancellation error, got %v", err)
+	} else if err := c.DELETE(ctx, "/jobs/missing"); err == nil {
+		t.Fatal("expected error for missing job")
+	} else if _, err := WaitJob[int](ctx, c, "/jobs/missing", nil); err == nil || err.Error() != "job not found" {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+
+	// finished jobs are discarded even if no new jobs are started
+	jm2 := NewJobManager(1, 0)
+	defer jm2.Close()
+	done := jm2.Start(func(context.Context, func(float64)) (any, error) { return nil, nil })
+	time.Sleep(300 * time.Millisecond)
+	if _, ok := jm2.Status(done.ID); ok {
+		t.Fatal("expected finished job to be pruned")
+	}
+}
+
+func TestBatch(t *testing.T) {
+	var mu sync.Mutex
+	values := map[string]int{"foo": 1}
+	srv := httptest.NewServer(Mux(map[string]Handler{
+		"GET /values/:key": func(c Context) {
+			mu.Lock()
+			defer mu.Unlock()
+			v, ok := values[c.PathParam("key")]
+			if !ok {
+				c.Error(errors.New("not found"), http.StatusNotFound)
+				return
+			}
+			c.Encode(v)
+		},
+		"PUT /values/:key": Adapt(BasicAuth("password"))(func(c Context) {
+			var v int
+			if c.Decode(&v) != nil {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			values[c.PathParam("key")] = v
+		}),
+		"GET /panic": func(c Context) {
+			panic("oops")
+		},
+	}, WithBatchRoute("/batch", 2)))
+	defer srv.Close()
+
+	c := &Client{BaseURL: srv.URL, Password: "password"}
+	var foo, bar int
+	b := c.Batch("/batch")
+	b.PUT("/values/bar", 2)
+	b.GET("/values/foo", &foo)
+	b.GET("/values/baz", new(int))
+	b.POST("/batch", nil, nil)
+	b.POST("/b%61tch", nil, nil)
+	b.GET("/panic", nil)
+	errs, err := b.Do(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	} else if errs[0] != nil || errs[1] != nil {
+		t.Fatal(errs)
+	} else if foo != 1 {
+		t.Fatalf("expected 1, got %v", foo)
+	} else if errs[2] == nil || errs[2].Error() != "not found" {
+		t.Fatalf("expected not found error, got %v", errs[2])
+	} else if errs[3] == nil || errs[3].Error() != "batch requests cannot be nested" {
+		t.Fatalf("expected nested batch to fail, got %v", errs[3])
+	} else if errs[4] == nil || errs[4].Error() != "batch requests cannot be nested" {
+		t.Fatalf("expected escaped nested batch to fail, got %v", errs[4])
+	} else if errs[5] == nil || errs[5].Error() != "Internal Server Error" {
+		t.Fatalf("expected panic to be recovered, got %v", errs[5])
+	} else if err := c.GET(context.Background(), "/values/bar", &bar); err != nil || bar != 2 {
+		t.Fatal(bar, err)
+	}
+
+	// sub-requests are subject to middleware, and inherit the batch's headers
+	c.Password = "wrong"
+	b = c.Batch("/batch")
+	b.PUT("/values/bar", 3)
+	if errs, err := b.Do(context.Background()); err != nil {
+		t.Fatal(err)
+	} else if errs[0] == nil || errs[0].Error() != "Unauthorized" {
+		t.Fatalf("expected unauthorized error, got %v", errs[0])
+	}
+}
+
+func TestIdempotent(t *testing.T) {
+	var mu sync.Mutex
+	var calls int
+	block := make(chan struct{})
+	idem := Adapt(Idempotent(NewMemoryIdempotencyStore(), time.Minute))
+	srv := httptest.NewServer(Mux(map[string]Handler{
+		"POST /charge": idem(func(c Context) {
+			var amount int
+			if c.Decode(&amount) != nil {
+				return
+			}
+			mu.Lock()
+			calls++
+			n := calls
+			mu.Unlock()
+			if amount == 0 {
+				<-block
+			} else if amount < 0 {
+				c.Error(errors.New("internal error"), http.StatusInternalServerError)
+				return
+			}
+			c.Encode(n)
+		}),
+	}))
+	defer srv.Close()
+	c := &Client{BaseURL: srv.URL}
+	ctx := context.Background()
+
+	// duplicate requests should be replayed
+	var r1, r2 int
+	if err := c.POST(ctx, "/charge", 100, &r1, WithHeader(IdempotencyKeyHeader, "foo")); err != nil {
+		t.Fatal(err)
+	} else if err := c.POST(ctx, "/charge", 100, &r2, WithHeader(IdempotencyKeyHeader, "foo")); err != nil {
+		t.Fatal(err)
+	} else if r1 != 1 || r2 != 1 {
+		t.Fatalf("expected replayed response, got %v and %v", r1, r2)
+	}
+
+	// reusing a key with a different body should be rejected
+	if err := c.POST(ctx, "/charge", 200, &r2, WithHeader(IdempotencyKeyHeader, "foo")); err == nil || !strings.Contains(err.Error(), "different request") {
+		t.Fatalf("expected key reuse error, got %v", err)
+	}
+
+	// keys are scoped to their principal
+	c.Password = "other"
+	if err := c.POST(ctx, "/charge", 100, &r2, WithHeader(IdempotencyKeyHeader, "foo")); err != nil {
+		t.Fatal(err)
+	} else if r2 != 2 {
+		t.Fatalf("expected new response for different principal, got %v", r2)
+	}
+	c.Password = ""
+
+	// a different key should execute the handler again
+	if err := c.POST(ctx, "/charge", 100, &r1, WithHeader(IdempotencyKeyHeader, "bar")); err != nil {
+		t.Fatal(err)
+	} else if r1 != 3 {
+		t.Fatalf("expected new response, got %v", r1)
+	}
+
+	// server errors should not be recorded
+	if err := c.POST(ctx, "/charge", -1, nil, WithHeader(IdempotencyKeyHeader, "baz")); err == nil {
+		t.Fatal("expected error")
+	} else if err := c.POST(ctx, "/charge", 100, &r1, WithHeader(IdempotencyKeyHeader, "baz")); err != nil {
+		t.Fatal(err)
+	} else if r1 != 5 {
+		t.Fatalf("expected handler to be retried, got %v", r1)
+	}
+
+	// concurrent duplicates should be rejected
+	errCh := make(chan error)
+	go func() { errCh <- c.POST(ctx, "/charge", 0, nil, WithHeader(IdempotencyKeyHeader, "qux")) }()
+	for {
+		mu.Lock()
+		n := calls
+		mu.Unlock()
+		if n == 6 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := c.POST(ctx, "/charge", 0, nil, WithHeader(IdempotencyKeyHeader, "qux")); err == nil || !strings.Contains(err.Error(), "in progress") {
+		t.Fatalf("expected in-progress error, got %v", err)
+	}
+	close(block)
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+
+	// clients can generate keys automatically
+	c.IdempotencyKeys = true
+	if err := c.POST(ctx, "/charge", 100, &r1); err != nil {
+		t.Fatal(err)
+	} else if err := c.POST(ctx, "/charge", 100, &r2); err != nil {
+		t.Fatal(err)
+	} else if r1 == r2 {
+		t.Fatal("expected distinct keys to be generated")
+	}
+
+	// oversized bodies should be rejected before they are buffered
+	small := Adapt(Idempotent(NewMemoryIdempotencyStore(), time.Minute, WithMaxIdempotentBodySize(8)))
+	srv2 := httptest.NewServer(Mux(map[string]Handler{
+		"POST /echo": small(func(c Context) {
+			var s string
+			if c.Decode(&s) != nil {
+				return
+			}
+			c.Encode(s)
+		}),
+	}))
+	defer srv2.Close()
+	c2 := &Client{BaseURL: srv2.URL}
+	var echo string
+	if err := c2.POST(ctx, "/echo", "foo", &echo, WithHeader(IdempotencyKeyHeader, "foo")); err != nil {
+		t.Fatal(err)
+	} else if err := c2.POST(ctx, "/echo", strings.Repeat("a", 100), &echo, WithHeader(IdempotencyKeyHeader, "bar")); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Fatalf("expected body size error, got %v", err)
+	}
+
+	// stored responses should expire
+	store := NewMemoryIdempotencyStore()
+	if _, err := store.Reserve("foo"); err != nil {
+		t.Fatal(err)
+	} else if err := store.Store("foo", StoredResponse{Status: 200}, time.Millisecond); err != nil {
+		t.Fatal(err)
+	} else if resp, err := store.Reserve("foo"); err != nil || resp == nil {
+		t.Fatal("expected stored response", resp, err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	if resp, err := store.Reserve("foo"); err != nil || resp != nil {
+		t.Fatal("expected response to expire", resp, err)
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	items := make([]int, 25)
+	for i := range items {
+		items[i] = i
+	}
+	srv := httptest.NewServer(Mux(map[string]Handler{
+		"GET /offset": func(c Context) {
+			var p PageParams
+			var start int
+			if c.DecodePage(&p, 10) != nil || c.DecodeForm("start", &start) != nil {
+				return
+			}
+			page := items[start:][min(p.Offset, len(items)-start):]
+			page = page[:min(p.Limit, len(page))]
+			var next *PageParams
+			if p.Offset+len(page) < len(items)-start {
+				next = &PageParams{Limit: p.Limit, Offset: p.Offset + len(page)}
+			}
+			c.EncodePage(page, next)
+		},
+		"GET /cursor": func(c Context) {
+			var p PageParams
+			if c.DecodePage(&p, 10) != nil {
+				return
+			}
+			var start int
+			if p.Cursor != "" {
+				start, _ = strconv.Atoi(p.Cursor)
+			}
+			end := min(start+p.Limit, len(items))
+			resp := Page[int]{Items: items[start:end]}
+			if end < len(items) {
+				resp.Next = c.NextPage(PageParams{Limit: p.Limit, Cursor: strconv.Itoa(end)})
+			}
+			c.Encode(resp)
+		},
+	}))
+	defer srv.Close()
+	c := &Client{BaseURL: srv.URL}
+
+	collect := func(route string) (got []int) {
+		t.Helper()
+		for v, err := range Paginate[int](context.Background(), c, route) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, v)
+		}
+		return
+	}
+	check := func(got, want []int) {
+		t.Helper()
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	}
+	check(collect("/offset"), items)
+	check(collect("/offset?limit=7&start=5"), items[5:])
+	check(collect("/offset?limit=1000"), items)
+	check(collect("/cursor?limit=4"), items)
+
+	// stopping early should not request further pages
+	var n int
+	for range Paginate[int](context.Background(), c, "/cursor") {
+		if n++; n == 3 {
+			break
+		}
+	}
+
+	if err := c.GET(context.Background(), "/offset?limit=0", new([]int)); err == nil {
+		t.Fatal("expected error for invalid limit")
+	} else if err := c.GET(context.Background(), "/offset?offset=1&cursor=foo", new([]int)); err == nil {
+		t.Fatal("expected error for offset and cursor")
+	}
+}
+
+func TestClientConnectionReuse(t *testing.T) {
+	srv := httptest.NewUnstartedServer(Mux(map[string]Handler{
+		"GET /foo": func(c Context) {
+			// trailing whitespace is not consumed by the JSON decoder
+			c.ResponseWriter.Write([]byte("1" + strings.Repeat(" ", 1000)))
+		},
+		"GET /slow": func(c Context) {
+			time.Sleep(100 * time.Millisecond)
+			c.Encode(1)
+		},
+	}))
+	var mu sync.Mutex
+	var conns int
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			mu.Lock()
+			conns++
+			mu.Unlock()
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	c := &Client{BaseURL: srv.URL, HTTPClient: &http.Client{Transport: NewTransport(4)}}
+	for range 10 {
+		var v int
+		if err := c.GET(context.Background(), "/foo", &v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if conns != 1 {
+		t.Fatalf("expected 1 connection, got %v", conns)
+	}
+
+	c.Timeout = 10 * time.Millisecond
+	if err := c.GET(context.Background(), "/slow", new(int)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	var mu sync.Mutex
+	var attempts, failures int
+	retryAfter := "0"
+	srv := httptest.NewServer(Mux(map[string]Handler{
+		"GET /foo": func(c Context) {
+			mu.Lock()
+			defer mu.Unlock()
+			if attempts++; attempts <= failures {
+				c.ResponseWriter.Header().Set("Retry-After", retryAfter)
+				c.Error(errors.New("unavailable"), http.StatusServiceUnavailable)
+				return
+			}
+			c.Encode(attempts)
+		},
+		"POST /foo": func(c Context) {
+			var v int
+			if c.Decode(&v) != nil {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if attempts++; attempts <= failures {
+				c.Error(errors.New("bad gateway"), http.StatusBadGateway)
+				return
+			}
+			c.Encode(v)
+		},
+	}))
+	defer srv.Close()
+	c := &Client{BaseURL: srv.URL, Retry: &RetryPolicy{MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}}
+	ctx := context.Background()
+	reset := func(n int) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts, failures = 0, n
+	}
+
+	reset(2)
+	var v int
+	if err := c.GET(ctx, "/foo", &v); err != nil {
+		t.Fatal(err)
+	} else if v != 3 {
+		t.Fatalf("expected 3 attempts, got %v", v)
+	}
+
+	// give up after MaxAttempts
+	reset(10)
+	if err := c.GET(ctx, "/foo", &v); err == nil || err.Error() != "unavailable" {
+		t.Fatalf("expected unavailable error, got %v", err)
+	} else if attempts != 4 {
+		t.Fatalf("expected 4 attempts, got %v", attempts)
+	}
+
+	// POSTs are retried with an automatically-generated idempotency key; the
+	// body is replayed
+	reset(1)
+	if err := c.POST(ctx, "/foo", 7, &v); err != nil {
+		t.Fatal(err)
+	} else if v != 7 {
+		t.Fatalf("expected 7, got %v", v)
+	}
+	// an empty key opts out of retries
+	reset(1)
+	if err := c.POST(ctx, "/foo", 7, &v, WithHeader(IdempotencyKeyHeader, "")); err == nil {
+		t.Fatal("expected error")
+	}
+
+	// don't retry if Retry-After exceeds the budget
+	reset(1)
+	retryAfter = "10"
+	c.Retry.MaxElapsed = time.Second
+	start := time.Now()
+	if err := c.GET(ctx, "/foo", &v); err == nil {
+		t.Fatal("expected error")
+	} else if time.Since(start) > time.Second {
+		t.Fatal("expected to give up immediately")
+	}
+
+	// don't retry if Retry-After exceeds MaxBackoff, even without a budget
+	reset(1)
+	retryAfter = "86400"
+	c.Retry.MaxElapsed = 0
+	start = time.Now()
+	if err := c.GET(ctx, "/foo", &v); err == nil {
+		t.Fatal("expected error")
+	} else if time.Since(start) > time.Second {
+		t.Fatal("expected to give up immediately")
+	}
+
+	// network errors are retried
+	srv.Close()
+	if err := c.GET(ctx, "/foo", &v); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var mu sync.Mutex
+	var calls int
+	healthy := false
+	srv := httptest.NewServer(Mux(map[string]Handler{
+		"GET /foo": func(c Context) {
+			mu.Lock()
+			defer mu.Unlock()
+			calls++
+			if !healthy {
+				c.Error(errors.New("unavailable"), http.StatusServiceUnavailable)
+				return
+			}
+			c.Encode(true)
+		},
+		"GET /bad": func(c Context) {
+			c.Error(errors.New("bad request"), http.StatusInternalServerError)
+		},
+		"GET /slow": func(c Context) {
+			time.Sleep(20 * time.Millisecond)
+			c.Encode(true)
+		},
+	}))
+	defer srv.Close()
+
+	var transitions []string
+	cb := &CircuitBreaker{
+		FailureThreshold: 3,
+		Cooldown:         50 * time.Millisecond,
+		OnStateChange: func(_ string, from, to CircuitState) {
+			transitions = append(transitions, from.String()+"->"+to.String())
+		},
+	}
+	c := &Client{BaseURL: srv.URL, Breaker: cb}
+	ctx := context.Background()
+
+	// application errors and the caller's own timeouts are not host failures
+	for range 5 {
+		if err := c.GET(ctx, "/bad", new(bool)); err == nil {
+			t.Fatal("expected error")
+		}
+		tctx, cancel := context.WithTimeout(ctx, time.Millisecond)
+		err := c.GET(tctx, "/slow", new(bool))
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected deadline exceeded, got %v", err)
+		}
+	}
+	if state := cb.State(strings.TrimPrefix(srv.URL, "http://")); state != CircuitClosed {
+		t.Fatalf("expected circuit to remain closed, got %v", state)
+	}
+
+	for range 3 {
+		if err := c.GET(ctx, "/foo", new(bool)); err == nil || err.Error() != "unavailable" {
+			t.Fatalf("expected unavailable error, got %v", err)
+		}
+	}
+	// circuit should now be open
+	var coe *CircuitOpenError
+	if err := c.GET(ctx, "/foo", new(bool)); !errors.As(err, &coe) {
+		t.Fatalf("expected CircuitOpenError, got %v", err)
+	} else if calls != 3 {
+		t.Fatalf("expected 3 calls, got %v", calls)
+	}
+
+	// after the cooldown, a failed probe reopens the circuit
+	time.Sleep(60 * time.Millisecond)
+	if err := c.GET(ctx, "/foo", new(bool)); err == nil || err.Error() != "unavailable" {
+		t.Fatalf("expected unavailable error, got %v", err)
+	} else if err := c.GET(ctx, "/foo", new(bool)); !errors.As(err, &coe) {
+		t.Fatalf("expected CircuitOpenError, got %v", err)
+	}
+
+	// a successful probe closes it
+	mu.Lock()
+	healthy = true
+	mu.Unlock()
+	time.Sleep(60 * time.Millisecond)
+	for range 2 {
+		if err := c.GET(ctx, "/foo", new(bool)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := "[closed->open open->half-open half-open->open open->half-open half-open->closed]"
+	if fmt.Sprint(transitions) != want {
+		t.Fatalf("expected transitions %v, got %v", want, transitions)
+	}
+
+	// circuit open errors should not be retried
+	c.Retry = &RetryPolicy{MinBackoff: time.Millisecond}
+	mu.Lock()
+	healthy = false
+	mu.Unlock()
+	for range 3 {
+		c.GET(ctx, "/foo", new(bool))
+	}
+	if err := c.GET(ctx, "/foo", new(bool)); !errors.As(err, &coe) {
+		t.Fatalf("expected CircuitOpenError, got %v", err)
+	}
+}
+
+func TestRequestOptions(t *testing.T) {
+	srv := httptest.NewServer(Mux(map[string]Handler{
+		"GET /foo": func(c Context) {
+			var n int
+			var tag string
+			if c.DecodeForm("n", &n) != nil || c.DecodeHeader("If-None-Match", &tag) != nil {
+				return
+			}
+			c.SetHeader("ETag", "v1")
+			if tag == "v1" {
+				c.ResponseWriter.WriteHeader(http.StatusNotModified)
+				return
+			}
+			c.Encode(n + len(c.Request.URL.Query()["m"]))
+		},
+	}))
+	defer srv.Close()
+	c := &Client{BaseURL: srv.URL}
+
+	var v int
+	var meta ResponseMeta
+	if err := c.GET(context.Background(), "/foo?m=x", &v, WithQuery("n", 3), WithQuery("m", "y"), CaptureResponse(&meta)); err != nil {
+		t.Fatal(err)
+	} else if v != 5 {
+		t.Fatalf("expected 5, got %v", v)
+	} else if meta.StatusCode != http.StatusOK || meta.Header.Get("ETag") != "v1" {
+		t.Fatalf("unexpected response metadata: %+v", meta)
+	}
+
+	err := c.GET(context.Background(), "/foo", &v, WithHeader("If-None-Match", "v1"), CaptureResponse(&meta))
+	if err == nil || err.Error() != "304 Not Modified" {
+		t.Fatalf("expected 304 error, got %v", err)
+	} else if meta.StatusCode != http.StatusNotModified {
+		t.Fatalf("expected status 304, got %v", meta.StatusCode)
+	}
+}
+
+func TestUploadDownload(t *testing.T) {
+	var mu sync.Mutex
+	objects := make(map[string][]byte)
+	srv := httptest.NewServer(Mux(map[string]Handler{
+		"PUT /objects/:key": func(c Context) {
+			var buf bytes.Buffer
+			if c.ReadBody(1000, func(r io.Reader) error {
+				_, err := io.Copy(&buf, r)
+				return err
+			}) != nil {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			objects[c.PathParam("key")] = buf.Bytes()
+		},
+		"GET /objects/:key": func(c Context) {
+			mu.Lock()
+			obj, ok := objects[c.PathParam("key")]
+			mu.Unlock()
+			if !ok {
+				c.Error(errors.New("not found"), http.StatusNotFound)
+				return
+			}
+			c.WriteBody(bytes.NewReader(obj), int64(len(obj)))
+		},
+	}))
+	defer srv.Close()
+	c := &Client{BaseURL: srv.URL}
+	ctx := context.Background()
+
+	data := frand.Bytes(800)
+	var sent int64
+	if err := c.Upload(ctx, http.MethodPut, "/objects/foo", io.MultiReader(bytes.NewReader(data)), int64(len(data)), nil, WithProgress(func(n, total int64) {
+		if total != int64(len(data)) {
+			t.Errorf("expected total %v, got %v", len(data), total)
+		}
+		sent = n
+	})); err != nil {
+		t.Fatal(err)
+	} else if sent != int64(len(data)) {
+		t.Fatalf("expected progress %v, got %v", len(data), sent)
+	}
+
+	var received int64
+	body, size, err := c.Download(ctx, "/objects/foo", WithProgress(func(n, _ int64) { received = n }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(body)
+	body.Close()
+	if err != nil {
+		t.Fatal(err)
+	} else if size != int64(len(data)) || !bytes.Equal(got, data) {
+		t.Fatal("downloaded data does not match")
+	} else if received != size {
+		t.Fatalf("expected progress %v, got %v", size, received)
+	}
+
+	// unknown size, too large
+	if err := c.Upload(ctx, http.MethodPut, "/objects/bar", io.MultiReader(bytes.NewReader(frand.Bytes(2000))), -1, nil); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Fatalf("expected too large error, got %v", err)
+	} else if _, _, err := c.Download(ctx, "/objects/bar"); err == nil || err.Error() != "not found" {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+}
+
+func TestClientResponseLimits(t *testing.T) {
+	type item struct {
+		Name string `json:"name"`
+	}
+	srv := httptest.NewServer(Mux(map[string]Handler{
+		"GET /item": func(c Context) {
+			c.Encode(map[string]any{"name": "foo", "extra": true})
+		},
+		"GET /list": func(c Context) {
+			c.Encode(make([]int, 100))
+		},
+		"GET /error": func(c Context) {
+			c.Error(errors.New(strings.Repeat("x", 100)), http.StatusInternalServerError)
+		},
+	}, WithCompactJSON()))
+	defer srv.Close()
+	c := &Client{BaseURL: srv.URL}
+	ctx := context.Background()
+
+	var it item
+	if err := c.GET(ctx, "/item", &it); err != nil || it.Name != "foo" {
+		t.Fatal(it, err)
+	}
+	c.DisallowUnknownFields = true
+	if err := c.GET(ctx, "/item", &it); err == nil || !strings.Contains(err.Error(), "unknown field") {
+		t.Fatalf("expected unknown field error, got %v", err)
+	}
+
+	// the list is 201 bytes long: "[0,0,...,0]"
+	var list []int
+	c.MaxResponseSize = 201
+	if err := c.GET(ctx, "/list", &list); err != nil || len(list) != 100 {
+		t.Fatal(len(list), err)
+	}
+	c.MaxResponseSize = 200
+	if err := c.GET(ctx, "/list", &list); !errors.Is(err, ErrResponseTooLarge) {
+		t.Fatalf("expected ErrResponseTooLarge, got %v", err)
+	}
+
+	c.MaxErrorSize = 10
+	if err := c.GET(ctx, "/error", &list); err == nil || err.Error() != strings.Repeat("x", 10) {
+		t.Fatalf("expected truncated error, got %v", err)
+	}
+}
+
+func TestGenericHelpers(t *testing.T) {
+	var value int
+	srv := httptest.NewServer(Mux(map[string]Handler{
+		"GET /value": func(c Context) { c.Encode(value) },
+		"PUT /value": func(c Context) {
+			if c.Decode(&value) != nil {
+				return
+			}
+		},
+		"POST /add": func(c Context) {
+			var n int
+			if c.Decode(&n) != nil {
+				return
+			}
+			value += n
+			c.Encode(strconv.Itoa(value))
+		},
+		"PATCH /value": func(c Context) {
+			var n int
+			if c.Decode(&n) != nil {
+				return
+			}
+			value *= n
+			c.Encode(value)
+		},
+		"POST /reset": func(c Context) {
+			if c.Decode(&value) != nil {
+				return
+			}
+			c.Encode(nil) // 204 No Content
+		},
+		"PATCH /noop": func(c Context) {
+			var n int
+			c.Decode(&n) // 200 with an empty body
+		},
+	}))
+	defer srv.Close()
+	c := &Client{BaseURL: srv.URL}
+	ctx := context.Background()
+
+	if err := PUT(ctx, c, "/value", 3); err != nil {
+		t.Fatal(err)
+	} else if s, err := POST[int, string](ctx, c, "/add", 4); err != nil || s != "7" {
+		t.Fatal(s, err)
+	} else if v, err := PATCH[int, int](ctx, c, "/value", 2); err != nil || v != 14 {
+		t.Fatal(v, err)
+	} else if v, err := GET[int](ctx, c, "/value"); err != nil || v != 14 {
+		t.Fatal(v, err)
+	} else if _, err := GET[int](ctx, c, "/missing"); err == nil {
+		t.Fatal("expected error")
+	}
+
+	// empty responses decode as the zero value
+	if v, err := POST[int, int](ctx, c, "/reset", 5); err != nil || v != 0 {
+		t.Fatal(v, err)
+	} else if s, err := PATCH[int, string](ctx, c, "/noop", 1); err != nil || s != "" {
+		t.Fatal(s, err)
+	} else if v, err := GET[int](ctx, c, "/value"); err != nil || v != 5 {
+		t.Fatal(v, err)
+	}
+}
+
+func TestHeadOptions(t *testing.T) {
+	var idx IndexNotifier
+	srv := httptest.NewServer(Mux(map[string]Handler{
+		"GET /foo": func(c Context) {
+			c.SetHeader("ETag", "v1")
+			c.Encode("hello")
+		},
+		"PUT /foo": func(c Context) {},
+		"GET /bar": func(c Context) {
+			c.Encode("bar")
+		},
+		"HEAD /bar": func(c Context) {
+			c.SetHeader("X-Count", 3)
+		},
+		"GET /items/:name": func(c Context) {
+			c.Encode(c.PathParam("name"))
+		},
+		"HEAD /items/:id": func(c Context) {
+			c.SetHeader("X-ID", c.PathParam("id"))
+		},
+		"GET /value": func(c Context) {
+			if c.WaitIndex(&idx) != nil {
+				return
+			}
+			c.Encode(idx.Index())
+		},
+		"GET /events": func(c Context) {
+			es := c.Events(time.Second)
+			defer es.Close()
+			for es.Send("tick", "", 1) == nil {
+				time.Sleep(10 * time.Millisecond)
+			}
+		},
+	}))
+	defer srv.Close()
+	c := &Client{BaseURL: srv.URL}
+
+	h, err := c.HEAD(context.Background(), "/foo")
+	if err != nil {
+		t.Fatal(err)
+	} else if h.Get("ETag") != "v1" {
+		t.Fatalf("expected ETag v1, got %q", h.Get("ETag"))
+	}
+	h, err = c.HEAD(context.Background(), "/bar")
+	if err != nil {
+		t.Fatal(err)
+	} else if h.Get("X-Count") != "3" {
+		t.Fatalf("expected explicit HEAD handler to be used, got %v", h)
+	}
+	if _, err := c.HEAD(context.Background(), "/baz"); err == nil || err.Error() != "404 Not Found" {
+		t.Fatalf("expected 404 error, got %v", err)
+	}
+	h, err = c.HEAD(context.Background(), "/items/foo")
+	if err != nil {
+		t.Fatal(err)
+	} else if h.Get("X-ID") != "foo" {
+		t.Fatalf("expected explicit HEAD handler to be used, got %v", h)
+	}
+
+	// blocking queries and event streams should return immediately
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	h, err = c.HEAD(ctx, "/value?index=0&wait=1m")
+	if err != nil {
+		t.Fatal(err)
+	} else if h.Get("X-Index") != "0" {
+		t.Fatalf("expected X-Index 0, got %q", h.Get("X-Index"))
+	}
+	h, err = c.HEAD(ctx, "/events")
+	if err != nil {
+		t.Fatal(err)
+	} else if h.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("expected event stream, got %q", h.Get("Content-Type"))
+	}
+
+	h, err = c.OPTIONS(context.Background(), "/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	allow := strings.Split(h.Get("Allow"), ", ")
+	for _, m := range []string{"GET", "HEAD", "PUT", "OPTIONS"} {
+		if !slices.Contains(allow, m) {
+			t.Fatalf("expected Allow to contain %v, got %q", m, h.Get("Allow"))
+		}
+	}
+
+	// disable implicit HEAD routes
+	srv2 := httptest.NewServer(Mux(map[string]Handler{
+		"GET /foo": func(c Context) { c.Encode("hello") },
+	}, WithoutImplicitHEAD()))
+	defer srv2.Close()
+	c2 := &Client{BaseURL: srv2.URL}
+	if _, err := c2.HEAD(context.Background(), "/foo"); err == nil || err.Error() != "405 Method Not Allowed" {
+		t.Fatalf("expected 405 error, got %v", err)
+	}
+	h, err = c2.OPTIONS(context.Background(), "/foo")
+	if err != nil {
+		t.Fatal(err)
+	} else if slices.Contains(strings.Split(h.Get("Allow"), ", "), "HEAD") {
+		t.Fatalf("expected Allow not to contain HEAD, got %q", h.Get("Allow"))
+	}
+}
+
+func TestPutDeleteBodies(t *testing.T) {
+	var mu sync.Mutex
+	items := map[string]int{"a1": 1, "a2": 2, "b1": 3}
+	srv := httptest.NewServer(Mux(map[string]Handler{
+		"PUT /items/:key": func(c Context) {
+			var v int
+			if c.Decode(&v) != nil {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			items[c.PathParam("key")] = v
+			c.Encode(len(items))
+		},
+		"DELETE /items": func(c Context) {
+			var prefix string
+			if c.Decode(&prefix) != nil {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			var deleted []string
+			for k := range items {
+				if strings.HasPrefix(k, prefix) {
+					deleted = append(deleted, k)
+					delete(items, k)
+				}
+			}
+			slices.Sort(deleted)
+			c.Encode(deleted)
+		},
+	}))
+	defer srv.Close()
+	c := &Client{BaseURL: srv.URL}
+
+	var n int
+	if err := c.PUTWithResponse(context.Background(), "/items/c1", 4, &n); err != nil {
+		t.Fatal(err)
+	} else if n != 4 {
+		t.Fatalf("expected 4 items, got %v", n)
+	}
+
+	var deleted []string
+	if err := c.DELETEWithBody(context.Background(), "/items", "a", &deleted); err != nil {
+		t.Fatal(err)
+	} else if !slices.Equal(deleted, []string{"a1", "a2"}) {
+		t.Fatalf("unexpected deleted items: %v", deleted)
+	}
+	if err := c.DELETEWithBody(context.Background(), "/items", "b", nil); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(items) != 1 {
+		t.Fatalf("expected 1 remaining item, got %v", items)
+	}
+}
+
+func TestInterceptors(t *testing.T) {
+	srv := httptest.NewServer(Mux(map[string]Handler{
+		"POST /objects/:key": func(c Context) {
+			if c.Request.Header.Get("Authorization") != "Bearer new" {
+				c.Error(errors.New("unauthorized"), http.StatusUnauthorized)
+				return
+			}
+			var s string
+			if c.Decode(&s) != nil {
+				return
+			}
+			c.Encode(c.PathParam("key") + s)
+		},
+		"GET /ws": func(c Context) {
+			if c.Request.Header.Get("Authorization") != "Bearer new" {
+				c.Error(errors.New("unauthorized"), http.StatusUnauthorized)
+				return
+			}
+			ws, err := UpgradeWebSocket[string, string](c)
+			if err != nil {
+				return
+			}
+			defer ws.Close()
+			if s, err := ws.Read(); err == nil {
+				ws.Write(s)
+			}
+		},
+	}))
+	defer srv.Close()
+
+	var refreshes int
+	var observed []string
+	var logs bytes.Buffer
+	c := &Client{
+		BaseURL: srv.URL,
+		Interceptors: []Interceptor{
+			ObserveRequests(func(info RequestInfo, status int, _ time.Duration, err error) {
+				observed = append(observed, fmt.Sprintf("%v %v %v %v", info.Method, info.Route, status, err))
+			}),
+			LogRequests(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))),
+			BearerAuth(func(ctx context.Context, refresh bool) (string, error) {
+				if refresh {
+					refreshes++
+					return "new", nil
+				}
+				return "old", nil
+			}),
+		},
+	}
+
+	var resp string
+	if err := c.POST(context.Background(), "/objects/foo?bar=baz", "qux", &resp); err != nil {
+		t.Fatal(err)
+	} else if resp != "fooqux" {
+		t.Fatalf("expected fooqux, got %q", resp)
+	} else if refreshes != 1 {
+		t.Fatalf("expected 1 token refresh, got %v", refreshes)
+	}
+	if err := c.POST(context.Background(), "/objects/bar", "qux", &resp, WithRouteTemplate("/objects/:key")); err != nil {
+		t.Fatal(err)
+	}
+
+	// WebSocket handshakes pass through the interceptors too
+	refreshes = 0
+	ws, err := DialWebSocket[string, string](context.Background(), c, "/ws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	if err := ws.Write("hello"); err != nil {
+		t.Fatal(err)
+	} else if s, err := ws.Read(); err != nil || s != "hello" {
+		t.Fatal(s, err)
+	} else if refreshes != 1 {
+		t.Fatalf("expected 1 token refresh, got %v", refreshes)
+	}
+
+	exp := []string{
+		"POST /objects/foo 200 <nil>",
+		"POST /objects/:key 200 <nil>",
+		"GET /ws 101 <nil>",
+	}
+	if !slices.Equal(observed, exp) {
+		t.Fatalf("expected %q, got %q", exp, observed)
+	} else if !strings.Contains(logs.String(), "route=/objects/:key") {
+		t.Fatalf("unexpected log output: %q", logs.String())
+	}
+}
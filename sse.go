@@ -0,0 +1,243 @@
+package jape
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An EventStream writes Server-Sent Events to a client. It is safe for
+// concurrent use.
+type EventStream struct {
+	w           http.ResponseWriter
+	rc          *http.ResponseController
+	ctx         context.Context
+	lastEventID string
+
+	mu       sync.Mutex
+	err      error
+	stop     chan struct{}
+	stopOnce sync.Once
+	closed   sync.WaitGroup
+}
+
+// write writes p to the client and flushes it. If a previous write failed, it
+// returns that error.
+func (es *EventStream) write(p []byte) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if es.err != nil {
+		return es.err
+	} else if _, err := es.w.Write(p); err != nil {
+		es.err = err
+	} else if err := es.rc.Flush(); err != nil {
+		es.err = err
+	}
+	return es.err
+}
+
+// LastEventID returns the value of the request's Last-Event-ID header, which
+// clients set when reconnecting. Handlers can use it to resume the stream
+// after the last event the client received.
+func (es *EventStream) LastEventID() string {
+	return es.lastEventID
+}
+
+// Done returns a channel that is closed when the client disconnects.
+func (es *EventStream) Done() <-chan struct{} {
+	return es.ctx.Done()
+}
+
+// Send writes an event with the specified name, id, and JSON-encoded data. If
+// name is empty, the client treats it as a "message" event. If id is empty,
+// the client's last event ID is unchanged. name and id must not contain line
+// breaks, which would allow them to inject additional fields or events.
+func (es *EventStream) Send(name, id string, data any) error {
+	if strings.ContainsAny(name, "\r\n") {
+		return fmt.Errorf("invalid event name %q: must not contain line breaks", name)
+	} else if strings.ContainsAny(id, "\r\n\x00") {
+		return fmt.Errorf("invalid event ID %q: must not contain line breaks or NUL", id)
+	}
+	js, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("couldn't encode event data (%T): %w", data, err)
+	}
+	var sb strings.Builder
+	if id != "" {
+		sb.WriteString("id: " + id + "\n")
+	}
+	if name != "" {
+		sb.WriteString("event: " + name + "\n")
+	}
+	sb.WriteString("data: ")
+	sb.Write(js)
+	sb.WriteString("\n\n")
+	return es.write([]byte(sb.String()))
+}
+
+// SetRetry tells the client how long to wait before reconnecting if the
+// stream is interrupted.
+func (es *EventStream) SetRetry(d time.Duration) error {
+	return es.write([]byte("retry: " + strconv.FormatInt(d.Milliseconds(), 10) + "\n\n"))
+}
+
+// Close stops the stream's heartbeats. It must be called before the handler
+// returns.
+func (es *EventStream) Close() {
+	es.stopOnce.Do(func() { close(es.stop) })
+	es.closed.Wait()
+}
+
+// Events upgrades the response to an event stream, as defined by the
+// Server-Sent Events specification. If heartbeat is non-zero, a comment is
+// written at that interval to keep the connection alive. The returned stream
+// must be closed before the handler returns. In response to a HEAD request,
+// the stream is immediately done, and Send returns an error.
+func (c Context) Events(heartbeat time.Duration) *EventStream {
+	h := c.ResponseWriter.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	c.ResponseWriter.WriteHeader(http.StatusOK)
+
+	es := &EventStream{
+		w:           c.ResponseWriter,
+		rc:          http.NewResponseController(c.ResponseWriter),
+		ctx:         c.Request.Context(),
+		lastEventID: c.Request.Header.Get("Last-Event-ID"),
+		stop:        make(chan struct{}),
+	}
+	if c.Request.Method == http.MethodHead {
+		ctx, cancel := context.WithCancel(es.ctx)
+		cancel()
+		es.ctx = ctx
+		es.err = errors.New("cannot send events in response to a HEAD request")
+		return es
+	}
+	es.rc.Flush()
+	if heartbeat > 0 {
+		es.closed.Add(1)
+		go func() {
+			defer es.closed.Done()
+			t := time.NewTicker(heartbeat)
+			defer t.Stop()
+			for {
+				select {
+				case <-es.stop:
+					return
+				case <-es.ctx.Done():
+					return
+				case <-t.C:
+					if es.write([]byte(": heartbeat\n\n")) != nil {
+						return
+					}
+				}
+			}
+		}()
+	}
+	return es
+}
+
+// An Event is a Server-Sent Event received by Subscribe.
+type Event[T any] struct {
+	ID   string
+	Name string
+	Data T
+}
+
+// readEvents reads events from r, yielding each one. It returns when reading
+// from r fails, or when yield returns false, in which case stopped is true.
+// lastID and retry are updated as the corresponding fields are received.
+func readEvents[T any](r io.Reader, lastID *string, retry *time.Duration, yield func(Event[T], error) bool) (stopped bool, err error) {
+	br := bufio.NewReader(r)
+	var name string
+	var data []string
+	for {
+		line, err := br.ReadString('\n')
+		if errors.Is(err, io.EOF) {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		if line == "" {
+			// dispatch
+			if len(data) > 0 {
+				e := Event[T]{ID: *lastID, Name: name}
+				if err := json.Unmarshal([]byte(strings.Join(data, "\n")), &e.Data); err != nil {
+					err = fmt.Errorf("couldn't decode event data: %w", err)
+					if !yield(e, err) {
+						return true, nil
+					}
+				} else if !yield(e, nil) {
+					return true, nil
+				}
+			}
+			name, data = "", nil
+			continue
+		} else if strings.HasPrefix(line, ":") {
+			continue // comment
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			name = value
+		case "data":
+			data = append(data, value)
+		case "id":
+			*lastID = value
+		case "retry":
+			if ms, err := strconv.Atoi(value); err == nil {
+				*retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+}
+
+// Subscribe performs a GET request to a route that responds with
+// Context.Events, returning an iterator over the decoded events. If the
+// connection fails or the stream ends, the error (if any) is yielded, and
+// Subscribe reconnects after the delay requested by the server (1 second by
+// default), resuming from the last event ID it received. Iteration continues
+// until the caller stops it or ctx is canceled.
+func Subscribe[T any](ctx context.Context, c *Client, route string, opts ...RequestOption) iter.Seq2[Event[T], error] {
+	return func(yield func(Event[T], error) bool) {
+		var lastID string
+		retry := time.Second
+		for {
+			reqOpts := append([]RequestOption{WithHeader("Accept", "text/event-stream")}, opts...)
+			if lastID != "" {
+				reqOpts = append(reqOpts, WithHeader("Last-Event-ID", lastID))
+			}
+			r, err := c.do(ctx, http.MethodGet, route, nil, reqOpts...)
+			if err == nil {
+				var stopped bool
+				stopped, err = readEvents(r.Body, &lastID, &retry, yield)
+				r.Body.Close()
+				if stopped {
+					return
+				}
+			}
+			if ctx.Err() != nil {
+				return
+			} else if err != nil && !yield(Event[T]{}, err) {
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retry):
+			}
+		}
+	}
+}
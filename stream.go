@@ -0,0 +1,268 @@
+package jape
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"mime"
+	"net/http"
+	"reflect"
+)
+
+const ndjsonContentType = "application/x-ndjson"
+
+// Trailers sent by EncodeStream, allowing clients to distinguish a complete
+// stream from a truncated one.
+const (
+	streamStatusTrailer = "Stream-Status"
+	streamErrorTrailer  = "Stream-Error"
+)
+
+// EncodeStream writes the elements of seq to the response body as
+// newline-delimited JSON, flushing after each element. seq must be an
+// iter.Seq[T] or a channel of T; channels are read until they are closed.
+// Iteration stops early if the client disconnects or an element cannot be
+// marshalled, in which case the error is reported to the Mux's error hook.
+// Once iteration completes, a Stream-Status trailer is sent, so that clients
+// can detect truncated streams; if an element could not be marshalled, the
+// error is also sent in a Stream-Error trailer. In response to a HEAD request,
+// seq is not iterated.
+//
+// If a channel is not read until it is closed, e.g. because the client
+// disconnected, its remaining elements are discarded in the background until
+// it is closed, so that its producer does not block forever. Producers that
+// never close their channel should stop sending when the request's context is
+// done.
+func (c Context) EncodeStream(seq any) {
+	val := reflect.ValueOf(seq)
+	typ := val.Type()
+	isSeq := typ.Kind() == reflect.Func && typ.NumIn() == 1 && typ.NumOut() == 0 &&
+		typ.In(0).Kind() == reflect.Func && typ.In(0).NumIn() == 1 && typ.In(0).NumOut() == 1 && typ.In(0).Out(0).Kind() == reflect.Bool
+	isChan := typ.Kind() == reflect.Chan && typ.ChanDir()&reflect.RecvDir != 0
+	if !isSeq && !isChan {
+		panic(fmt.Sprintf("EncodeStream called on %T, which is neither an iter.Seq nor a channel", seq))
+	}
+
+	h := c.ResponseWriter.Header()
+	h.Set("Content-Type", ndjsonContentType)
+	h.Set("Trailer", streamStatusTrailer+", "+streamErrorTrailer)
+	c.ResponseWriter.WriteHeader(http.StatusOK)
+	if c.Request.Method == http.MethodHead {
+		if isChan {
+			go drainChan(val)
+		}
+		return
+	}
+	rc := http.NewResponseController(c.ResponseWriter)
+	ctx := c.Request.Context()
+	var encodeErr, writeErr error
+	write := func(v reflect.Value) bool {
+		buf := getBuffer()
+		defer putBuffer(buf)
+		if err := json.NewEncoder(buf).Encode(v.Interface()); err != nil {
+			encodeErr = fmt.Errorf("couldn't encode stream element (%v): %w", v.Type(), err)
+			c.reportError(encodeErr)
+			return false
+		} else if _, writeErr = c.ResponseWriter.Write(buf.Bytes()); writeErr != nil {
+			return false
+		}
+		rc.Flush()
+		return ctx.Err() == nil
+	}
+
+	if isChan {
+		cases := []reflect.SelectCase{
+			{Dir: reflect.SelectRecv, Chan: val},
+			{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())},
+		}
+		for {
+			chosen, v, ok := reflect.Select(cases)
+			if chosen == 0 && !ok {
+				break
+			} else if chosen != 0 || !write(v) {
+				go drainChan(val)
+				break
+			}
+		}
+	} else {
+		yield := reflect.MakeFunc(typ.In(0), func(args []reflect.Value) []reflect.Value {
+			return []reflect.Value{reflect.ValueOf(write(args[0]))}
+		})
+		val.Call([]reflect.Value{yield})
+	}
+
+	switch {
+	case encodeErr != nil:
+		h.Set(streamStatusTrailer, "error")
+		h.Set(streamErrorTrailer, encodeErr.Error())
+	case writeErr == nil && ctx.Err() == nil:
+		h.Set(streamStatusTrailer, "ok")
+	}
+}
+
+// drainChan receives from ch until it is closed.
+func drainChan(ch reflect.Value) {
+	for {
+		if _, ok := ch.Recv(); !ok {
+			return
+		}
+	}
+}
+
+var errElementTooLarge = errors.New("request element too large")
+
+// An elementReader limits the number of bytes that can be read from a request
+// body while decoding a single stream element.
+type elementReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (er *elementReader) Read(p []byte) (int, error) {
+	if er.remaining <= 0 {
+		return 0, errElementTooLarge
+	} else if int64(len(p)) > er.remaining {
+		p = p[:er.remaining]
+	}
+	n, err := er.r.Read(p)
+	er.remaining -= int64(n)
+	return n, err
+}
+
+// DecodeStreamLimit decodes the request body one element at a time. If the
+// request has a Content-Type of application/x-ndjson, the body is decoded as
+// newline-delimited JSON; otherwise, it must be a JSON array. For each
+// element, v is zeroed, the element is decoded into it, and fn is called. If
+// an element is larger than n bytes, or decoding fails, DecodeStreamLimit
+// writes an error to the response body and returns it. If fn returns an error,
+// DecodeStreamLimit stops and returns it without writing a response; fn is
+// responsible for doing so.
+func (c Context) DecodeStreamLimit(v any, n int64, fn func() error) error {
+	val := reflect.ValueOf(v)
+	if val.Kind() != reflect.Pointer {
+		panic(fmt.Sprintf("DecodeStream called on non-pointer type %T", v))
+	}
+	er := &elementReader{r: c.Request.Body}
+	dec := json.NewDecoder(er)
+	// the decoder reads ahead, so allow some slack beyond the element limit;
+	// the exact size is checked after decoding
+	const slack = 4096
+	more := func() bool {
+		er.remaining = n + slack
+		return dec.More()
+	}
+	decodeElem := func(i int) error {
+		val.Elem().SetZero()
+		start := dec.InputOffset()
+		if err := dec.Decode(v); errors.Is(err, errElementTooLarge) || (err == nil && dec.InputOffset()-start > n) {
+			return c.Error(fmt.Errorf("request element %d too large", i), http.StatusRequestEntityTooLarge)
+		} else if err != nil {
+			return c.Error(fmt.Errorf("couldn't decode request element %d (%T): %w", i, v, err), http.StatusBadRequest)
+		}
+		return fn()
+	}
+
+	if mt, _, _ := mime.ParseMediaType(c.Request.Header.Get("Content-Type")); mt == ndjsonContentType {
+		for i := 0; more(); i++ {
+			if err := decodeElem(i); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	er.remaining = slack
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		return c.Error(errors.New("request body must be a JSON array"), http.StatusBadRequest)
+	}
+	for i := 0; more(); i++ {
+		if err := decodeElem(i); err != nil {
+			return err
+		}
+	}
+	er.remaining = slack
+	if _, err := dec.Token(); err != nil {
+		return c.Error(fmt.Errorf("couldn't decode request body: %w", err), http.StatusBadRequest)
+	}
+	return nil
+}
+
+// DecodeStream is like DecodeStreamLimit, but limits each element to 1 MB.
+func (c Context) DecodeStream(v any, fn func() error) error {
+	return c.DecodeStreamLimit(v, 1e6, fn) // 1 MB
+}
+
+// Stream performs a GET request to a route that responds with
+// Context.EncodeStream, returning an iterator over the decoded elements. The
+// request is sent when iteration begins. If an error occurs, including the
+// server failing to send the remainder of the stream, it is yielded as the
+// final element.
+func Stream[T any](ctx context.Context, c *Client, route string, opts ...RequestOption) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+		r, err := c.do(ctx, http.MethodGet, route, nil, opts...)
+		if err != nil {
+			yield(zero, err)
+			return
+		}
+		defer r.Body.Close()
+		dec := json.NewDecoder(r.Body)
+		for {
+			var v T
+			if err := dec.Decode(&v); errors.Is(err, io.EOF) {
+				if err := streamTrailerError(r.Trailer); err != nil {
+					yield(zero, err)
+				}
+				return
+			} else if err != nil {
+				yield(zero, err)
+				return
+			} else if !yield(v, nil) {
+				return
+			}
+		}
+	}
+}
+
+// streamTrailerError returns the error reported by the trailers of a response
+// written by EncodeStream, which must have been read to completion.
+func streamTrailerError(trailer http.Header) error {
+	if _, ok := trailer[streamStatusTrailer]; !ok {
+		return nil // server predates trailers
+	}
+	switch trailer.Get(streamStatusTrailer) {
+	case "ok":
+		return nil
+	case "error":
+		return errors.New(trailer.Get(streamErrorTrailer))
+	default:
+		return errors.New("stream ended unexpectedly")
+	}
+}
+
+// POSTStream performs a POST request whose body is the elements of seq, encoded
+// as newline-delimited JSON. The body is encoded as it is sent, so seq is never
+// held in memory in its entirety. If r is non-nil, the response is decoded into
+// it. The server should read the body with Context.DecodeStream.
+func POSTStream[T any](ctx context.Context, c *Client, route string, seq iter.Seq[T], r any, opts ...RequestOption) error {
+	pr, pw := io.Pipe()
+	defer pr.Close() // unblocks the encoder if the request fails early
+	go func() {
+		enc := json.NewEncoder(pw)
+		for v := range seq {
+			if err := enc.Encode(v); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.Close()
+	}()
+	resp, err := c.do(ctx, http.MethodPost, route, pr, append([]RequestOption{WithHeader("Content-Type", ndjsonContentType)}, opts...)...)
+	if err != nil {
+		return err
+	}
+	return c.decodeResponse(resp, r)
+}
@@ -0,0 +1,25 @@
+package bodies
+
+import (
+	"context"
+	"fmt"
+
+	"go.sia.tech/jape"
+)
+
+type client struct{ c jape.Client }
+
+func (c *client) put(key string, n int) (s string, err error) {
+	err = c.c.PUTWithResponse(context.Background(), fmt.Sprintf("/items/%s", key), n, &s)
+	return
+}
+
+func (c *client) deletePrefix(prefix string) (deleted []string, err error) {
+	err = c.c.DELETEWithBody(context.Background(), "/items", prefix, &deleted)
+	return
+}
+
+func (c *client) head() error {
+	_, err := c.c.HEAD(context.Background(), "/items")
+	return err
+}
@@ -0,0 +1,25 @@
+package bodies
+
+import "go.sia.tech/jape"
+
+func routes() map[string]jape.Handler {
+	return map[string]jape.Handler{
+		"PUT /items/:key": func(jc jape.Context) {
+			var n int
+			if jc.Decode(&n) != nil {
+				return
+			}
+			jc.Encode(jc.PathParam("key"))
+		},
+		"DELETE /items": func(jc jape.Context) {
+			var prefix string
+			if jc.Decode(&prefix) != nil {
+				return
+			}
+			jc.Encode([]string{prefix})
+		},
+		"HEAD /items": func(jc jape.Context) {
+			jc.Encode(1) // want `HEAD routes should not write a response object`
+		},
+	}
+}
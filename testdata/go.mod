@@ -0,0 +1,13 @@
+module example.com/japecheck
+
+go 1.24.0
+
+require go.sia.tech/jape v0.0.0
+
+require (
+	github.com/julienschmidt/httprouter v1.3.0 // indirect
+	golang.org/x/tools v0.41.0 // indirect
+	lukechampine.com/frand v1.5.1 // indirect
+)
+
+replace go.sia.tech/jape => ../
@@ -0,0 +1,5 @@
+package japecheck
+
+import "go.sia.tech/jape"
+
+type client struct{ c jape.Client }
@@ -0,0 +1,107 @@
+package japecheck
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"go.sia.tech/jape"
+)
+
+func formsDefaults(jc jape.Context) {
+	var u uint64
+	var f float64
+	var s string
+	var n int
+	if jc.DecodeFormDefault("u", &u, 10) != nil || jc.DecodeFormDefault("f", &f, 1) != nil || jc.DecodeFormDefault("s", &s, nil) != nil {
+		return
+	} else if jc.DecodeFormDefault("n", &n, "x") != nil { // want `Default for form value "n" has type string, which is not assignable to int`
+		return
+	}
+	jc.Encode(u)
+}
+
+func formsRequired(jc jape.Context) {
+	var id string
+	var limit int
+	if jc.DecodeFormRequired("id", &id) != nil || jc.DecodeForm("limit", &limit) != nil {
+		return
+	}
+	jc.Encode(id)
+}
+
+func formsStatus(jc jape.Context) {
+	var n int
+	if jc.Decode(&n) != nil {
+		return
+	} else if n == 0 {
+		jc.Accepted(nil)
+		return
+	}
+	jc.EncodeStatus(http.StatusCreated, n)
+}
+
+func formsStatusDynamic(jc jape.Context) {
+	var status int
+	if jc.Decode(&status) != nil {
+		return
+	}
+	jc.EncodeStatus(status, status)
+}
+
+func formsStatusInvalid(jc jape.Context) {
+	var n int
+	if jc.Decode(&n) != nil {
+		return
+	}
+	jc.EncodeStatus(http.StatusFound, n) // want `EncodeStatus called with non-success status code 302`
+}
+
+func (c *client) formsDefaults() (u uint64, err error) {
+	err = c.c.GET(context.Background(), fmt.Sprintf("/forms/defaults?u=%d&s=%s", u, "foo"), &u)
+	return
+}
+
+func (c *client) formsRequiredOption(id string) (s string, err error) {
+	err = c.c.GET(context.Background(), "/forms/required/option", &s, jape.WithQuery("id", id))
+	return
+}
+
+func (c *client) formsRequiredConst() (s string, err error) {
+	err = c.c.GET(context.Background(), "/forms/required/const?id=foo", &s)
+	return
+}
+
+func (c *client) formsRequiredSprintf(id string) (s string, err error) {
+	err = c.c.GET(context.Background(), fmt.Sprintf("/forms/required/sprintf?limit=10&id=%s", id), &s)
+	return
+}
+
+func (c *client) formsRequiredMissing() (s string, err error) {
+	err = c.c.GET(context.Background(), "/forms/required/missing?limit=10", &s) // want `Client does not send required query parameter "id" for GET /forms/required/missing`
+	return
+}
+
+func (c *client) formsStatusCreated() (n int, err error) {
+	err = c.c.POST(context.Background(), "/forms/status/created", 1, &n, jape.ExpectStatus(http.StatusCreated))
+	return
+}
+
+func (c *client) formsStatusAccepted() (n int, err error) {
+	err = c.c.POST(context.Background(), "/forms/status/accepted", 0, &n, jape.ExpectStatus(http.StatusAccepted))
+	return
+}
+
+func (c *client) formsStatusMissing() (n int, err error) {
+	err = c.c.POST(context.Background(), "/forms/status/missing", 1, &n, jape.ExpectStatus(http.StatusNoContent)) // want `Client expects status code 204, but POST /forms/status/missing never writes it`
+	return
+}
+
+func (c *client) formsStatusDynamic() (n int, err error) {
+	err = c.c.POST(context.Background(), "/forms/status/dynamic", 1, &n, jape.ExpectStatus(http.StatusNoContent))
+	return
+}
+
+func (c *client) formsStatusInvalid() error {
+	return c.c.POST(context.Background(), "/forms/status/invalid", 1, nil)
+}
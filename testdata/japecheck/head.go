@@ -0,0 +1,92 @@
+package japecheck
+
+import (
+	"context"
+	"fmt"
+
+	"go.sia.tech/jape"
+)
+
+func headGetObject(jc jape.Context) {
+	jc.SetHeader("ETag", "v1")
+	jc.Encode(jc.PathParam("key"))
+}
+
+func headPutObject(jc jape.Context) {
+	var s string
+	if jc.Decode(&s) != nil {
+		return
+	}
+	_ = jc.PathParam("key")
+}
+
+func headStats(jc jape.Context) {
+	jc.Encode(1)
+}
+
+func headStatsCount(jc jape.Context) {
+	jc.SetHeader("X-Count", 1)
+}
+
+func headDecode(jc jape.Context) {
+	var n int
+	if jc.Decode(&n) != nil { // want `HEAD routes should not read a request object`
+		return
+	}
+}
+
+func headEncode(jc jape.Context) {
+	jc.Encode(1) // want `OPTIONS routes should not write a response object`
+}
+
+func (c *client) headObject(key string) (s string, err error) {
+	err = c.c.GET(context.Background(), fmt.Sprintf("/head/objects/%s", key), &s)
+	return
+}
+
+func (c *client) headPutObject(key, s string) error {
+	return c.c.PUT(context.Background(), fmt.Sprintf("/head/objects/%s", key), s)
+}
+
+// HEAD requests to GET routes are answered by the GET handler
+func (c *client) headObjectETag(key string) (etag string, err error) {
+	_, err = c.c.HEAD(context.Background(), fmt.Sprintf("/head/objects/%s", key), jape.ReadHeader("ETag", &etag))
+	return
+}
+
+// OPTIONS requests are answered for any route
+func (c *client) headObjectMethods(key string) (allow string, err error) {
+	_, err = c.c.OPTIONS(context.Background(), fmt.Sprintf("/head/objects/%s", key), jape.ReadHeader("Allow", &allow))
+	return
+}
+
+func (c *client) headStats() (n int, err error) {
+	err = c.c.GET(context.Background(), "/head/stats", &n)
+	return
+}
+
+func (c *client) headStatsMethods() (allow int, err error) {
+	_, err = c.c.OPTIONS(context.Background(), "/head/stats", jape.ReadHeader("Allow", &allow)) // want `Client has wrong type for header "Allow" \(got \*int, should be \*string\)`
+	return
+}
+
+// explicit HEAD routes take precedence over implicit ones
+func (c *client) headStatsCount() (n string, err error) {
+	_, err = c.c.HEAD(context.Background(), "/head/stats", jape.ReadHeader("X-Count", &n)) // want `Client has wrong type for header "X-Count" \(got \*string, should be \*int\)`
+	return
+}
+
+func (c *client) headMissing() error {
+	_, err := c.c.HEAD(context.Background(), "/head/missing") // want `Client references route not defined by server: HEAD /head/missing`
+	return err
+}
+
+func (c *client) headDecode() error {
+	_, err := c.c.HEAD(context.Background(), "/head/decode")
+	return err
+}
+
+func (c *client) headEncode() error {
+	_, err := c.c.OPTIONS(context.Background(), "/head/encode")
+	return err
+}
@@ -0,0 +1,69 @@
+package japecheck
+
+import (
+	"context"
+
+	"go.sia.tech/jape"
+)
+
+func headersVersion(jc jape.Context) {
+	var v int
+	if jc.DecodeHeader("X-Version", &v) != nil {
+		return
+	}
+	jc.SetHeader("X-Count", uint64(v))
+	jc.Encode(v)
+}
+
+func headersConflict(jc jape.Context) {
+	var v int
+	var s string
+	if jc.DecodeHeader("X-Version", &v) != nil || jc.DecodeHeader("x-version", &s) != nil { // want `Header "X-Version" decoded as \*string, but was previously decoded as \*int`
+		return
+	}
+	jc.SetHeader("X-Count", v)
+	jc.SetHeader("X-Count", s) // want `Header "X-Count" set to string, but was previously set to int`
+	jc.Encode(v)
+}
+
+func headersNonPointer(jc jape.Context) {
+	var v int
+	if jc.DecodeHeader("X-Version", v) != nil { // want `DecodeHeader called on non-pointer value`
+		return
+	}
+	jc.Encode(v)
+}
+
+func headersCreate(jc jape.Context) {
+	var n int
+	if jc.Decode(&n) != nil {
+		return
+	}
+	jc.Created("/headers/items/1", n)
+}
+
+func (c *client) headersVersion(key string) (v int, err error) {
+	var count uint64
+	var etag string
+	// headers that the server does not declare, e.g. those handled by
+	// middleware, are not reported
+	err = c.c.GET(context.Background(), "/headers/version", &v, jape.WithHeader("X-Version", 1), jape.WithHeader(jape.IdempotencyKeyHeader, key), jape.ReadHeader("X-Count", &count), jape.ReadHeader("ETag", &etag))
+	return
+}
+
+func (c *client) headersConflict() (v int, err error) {
+	var count string
+	err = c.c.GET(context.Background(), "/headers/conflict", &v, jape.WithHeader("X-Version", "1"), jape.ReadHeader("X-Count", &count)) // want `Client has wrong type for header "X-Version" \(got string, should be int\)` `Client has wrong type for header "X-Count" \(got \*string, should be \*int\)`
+	return
+}
+
+func (c *client) headersNonPointer() (v int, err error) {
+	err = c.c.GET(context.Background(), "/headers/nonpointer", &v)
+	return
+}
+
+func (c *client) headersCreate() (loc string, err error) {
+	var n int
+	err = c.c.POST(context.Background(), "/headers/items", 1, &n, jape.ReadHeader("Location", &loc))
+	return
+}
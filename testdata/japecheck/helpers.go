@@ -0,0 +1,190 @@
+package japecheck
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"go.sia.tech/jape"
+)
+
+type item struct {
+	Name string `json:"name"`
+}
+
+// rawItem is marshalled via MarshalJSON, so its fields are not inspected.
+type rawItem struct {
+	C chan int
+}
+
+func (rawItem) MarshalJSON() ([]byte, error) { return json.Marshal("raw") }
+
+type badItem struct {
+	Name   string
+	Ignore chan int `json:"-"`
+	C      chan int
+}
+
+func helpersItem(jc jape.Context) {
+	jc.Encode(item{Name: "foo"})
+}
+
+func helpersCreate(jc jape.Context) {
+	var it item
+	if jc.Decode(&it) != nil {
+		return
+	}
+	jc.Encode(it.Name)
+}
+
+func helpersPut(jc jape.Context) {
+	it := item{Name: jc.PathParam("name")}
+	jc.Decode(&it)
+}
+
+func helpersDelete(jc jape.Context) {
+	var prefix string
+	if jc.Decode(&prefix) != nil { // want `DELETE routes should not read a request object`
+		return
+	}
+}
+
+func helpersPage(jc jape.Context) {
+	var p jape.PageParams
+	if jc.DecodePage(&p, 10) != nil {
+		return
+	}
+	jc.EncodePage([]item{{Name: "foo"}}, nil)
+}
+
+func helpersWatch(jc jape.Context) {
+	var n jape.IndexNotifier
+	if jc.WaitIndex(&n) != nil {
+		return
+	}
+	jc.Encode(n.Index())
+}
+
+func helpersStartJob(jc jape.Context) {
+	jc.StartJob(jm, nil)
+}
+
+func helpersStartJobConflict(jc jape.Context) {
+	if jm == nil {
+		jc.Encode(1)
+		return
+	}
+	jc.StartJob(jm, nil) // want `StartJob writes go.sia.tech/jape.JobStatus, but int was previously written`
+}
+
+func helpersConflict(jc jape.Context) {
+	if jm == nil {
+		jc.Encode(1)
+		return
+	}
+	jc.Encode("one") // want `Encode called on string, but was previously called on int`
+}
+
+func helpersRaw(jc jape.Context) {
+	jc.Encode(rawItem{})
+}
+
+func helpersBad(jc jape.Context) {
+	jc.Encode(badItem{}) // want `Response type example.com/japecheck/japecheck.badItem cannot be marshalled as JSON: field C: chan int is not supported`
+}
+
+func helpersBadMap(jc jape.Context) {
+	jc.Encode(map[item]int{}) // want `Response type map\[example.com/japecheck/japecheck.item\]int cannot be marshalled as JSON: map key type example.com/japecheck/japecheck.item is not supported`
+}
+
+func helpersComplex(jc jape.Context) {
+	jc.Encode([]complex128{}) // want `Response type \[\]complex128 cannot be marshalled as JSON: complex128 is not supported`
+}
+
+func (c *client) helpersItem() (item, error) {
+	return jape.GET[item](context.Background(), &c.c, "/helpers/item")
+}
+
+func (c *client) helpersItemWrong() (string, error) {
+	return jape.GET[string](context.Background(), &c.c, "/helpers/item/wrong") // want `Client has wrong response type for GET /helpers/item/wrong \(got string, should be example.com/japecheck/japecheck.item\)`
+}
+
+func (c *client) helpersCreate(it item) (string, error) {
+	return jape.POST[item, string](context.Background(), &c.c, "/helpers/items", it)
+}
+
+func (c *client) helpersCreateWrong(name string) (int, error) {
+	return jape.POST[string, int](context.Background(), &c.c, "/helpers/items/wrong", name) // want `Client has wrong request type for POST /helpers/items/wrong \(got string, should be example.com/japecheck/japecheck.item\)` `Client has wrong response type for POST /helpers/items/wrong \(got int, should be string\)`
+}
+
+func (c *client) helpersUpdate(it item) (string, error) {
+	return jape.PATCH[item, string](context.Background(), &c.c, "/helpers/items", it)
+}
+
+func (c *client) helpersPut(it item) error {
+	return jape.PUT(context.Background(), &c.c, fmt.Sprintf("/helpers/items/%s", it.Name), it)
+}
+
+func (c *client) helpersDelete() error {
+	return c.c.DELETE(context.Background(), "/helpers/items")
+}
+
+func (c *client) helpersItems() {
+	for range jape.Paginate[item](context.Background(), &c.c, "/helpers/items") {
+	}
+}
+
+func (c *client) helpersItemsNotPage() {
+	for range jape.Paginate[item](context.Background(), &c.c, "/helpers/items/notpage") { // want `Client paginates GET /helpers/items/notpage, which does not call DecodePage` `Client has wrong response type for GET /helpers/items/notpage \(got pages of example.com/japecheck/japecheck.item, should be example.com/japecheck/japecheck.item\)`
+	}
+}
+
+func (c *client) helpersItemsWrong() {
+	for range jape.Paginate[string](context.Background(), &c.c, "/helpers/items/wrong") { // want `Client has wrong response type for GET /helpers/items/wrong \(got pages of string, should be \[\]example.com/japecheck/japecheck.item\)`
+	}
+}
+
+func (c *client) helpersIndex() {
+	for range jape.Watch[uint64](context.Background(), &c.c, "/helpers/index", 0) {
+	}
+}
+
+func (c *client) helpersIndexPlain() {
+	for range jape.Watch[item](context.Background(), &c.c, "/helpers/index/plain", 0) { // want `Client watches GET /helpers/index/plain, which does not call WaitIndex`
+	}
+}
+
+func (c *client) helpersStartJob() (s jape.JobStatus, err error) {
+	err = c.c.POST(context.Background(), "/helpers/jobs", nil, &s)
+	return
+}
+
+func (c *client) helpersStartJobConflict() (n int, err error) {
+	err = c.c.POST(context.Background(), "/helpers/jobs/conflict", nil, &n)
+	return
+}
+
+func (c *client) helpersConflict() (n int, err error) {
+	err = c.c.GET(context.Background(), "/helpers/conflict", &n)
+	return
+}
+
+func (c *client) helpersRaw() (s string, err error) {
+	err = c.c.GET(context.Background(), "/helpers/raw", &s) // want `Client has wrong response type for GET /helpers/raw \(got \*string, should be \*example.com/japecheck/japecheck.rawItem\)`
+	return
+}
+
+func (c *client) helpersBad() (b badItem, err error) {
+	err = c.c.GET(context.Background(), "/helpers/bad", &b)
+	return
+}
+
+func (c *client) helpersBadMap() (m map[item]int, err error) {
+	err = c.c.GET(context.Background(), "/helpers/badmap", &m)
+	return
+}
+
+func (c *client) helpersComplex() (cs []complex128, err error) {
+	err = c.c.GET(context.Background(), "/helpers/complex", &cs)
+	return
+}
@@ -0,0 +1,67 @@
+package japecheck
+
+import "go.sia.tech/jape"
+
+var jm *jape.JobManager
+
+func routes() map[string]jape.Handler {
+	return map[string]jape.Handler{
+		"GET /headers/version":    headersVersion,
+		"GET /headers/conflict":   headersConflict,
+		"GET /headers/nonpointer": headersNonPointer,
+		"POST /headers/items":     headersCreate,
+
+		"GET /forms/defaults":         formsDefaults,
+		"GET /forms/required/option":  formsRequired,
+		"GET /forms/required/const":   formsRequired,
+		"GET /forms/required/sprintf": formsRequired,
+		"GET /forms/required/missing": formsRequired,
+		"POST /forms/status/created":  formsStatus,
+		"POST /forms/status/accepted": formsStatus,
+		"POST /forms/status/missing":  formsStatus,
+		"POST /forms/status/dynamic":  formsStatusDynamic,
+		"POST /forms/status/invalid":  formsStatusInvalid,
+
+		"GET /streams/seq":          streamsSeq,
+		"GET /streams/seq/plain":    streamsSeq,
+		"GET /streams/seq/wrong":    streamsSeq,
+		"GET /streams/chan":         streamsChan,
+		"GET /streams/notseq":       streamsNotSeq,
+		"GET /streams/plain":        streamsPlain,
+		"GET /streams/events":       streamsEvents,
+		"GET /streams/events/wrong": streamsEvents,
+		"GET /streams/events/mixed": streamsEventsMixed,
+		"GET /streams/ws":           streamsWebSocket,
+		"GET /streams/download":     streamsDownload,
+		"PUT /streams/upload":       streamsUpload,
+		"POST /streams/sum":         streamsSum,
+		"POST /streams/sum/plain":   streamsSum,
+
+		"GET /helpers/item":           helpersItem,
+		"GET /helpers/item/wrong":     helpersItem,
+		"POST /helpers/items":         helpersCreate,
+		"POST /helpers/items/wrong":   helpersCreate,
+		"PATCH /helpers/items":        helpersCreate,
+		"PUT /helpers/items/:name":    helpersPut,
+		"DELETE /helpers/items":       helpersDelete,
+		"GET /helpers/items":          helpersPage,
+		"GET /helpers/items/notpage":  helpersItem,
+		"GET /helpers/items/wrong":    helpersPage,
+		"GET /helpers/index":          helpersWatch,
+		"GET /helpers/index/plain":    helpersItem,
+		"POST /helpers/jobs":          helpersStartJob,
+		"POST /helpers/jobs/conflict": helpersStartJobConflict,
+		"GET /helpers/conflict":       helpersConflict,
+		"GET /helpers/raw":            helpersRaw,
+		"GET /helpers/bad":            helpersBad,
+		"GET /helpers/badmap":         helpersBadMap,
+		"GET /helpers/complex":        helpersComplex,
+
+		"GET /head/objects/:key": headGetObject,
+		"PUT /head/objects/:key": headPutObject,
+		"GET /head/stats":        headStats,
+		"HEAD /head/stats":       headStatsCount,
+		"HEAD /head/decode":      headDecode,
+		"OPTIONS /head/encode":   headEncode,
+	}
+}
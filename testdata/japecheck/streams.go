@@ -0,0 +1,142 @@
+package japecheck
+
+import (
+	"context"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"go.sia.tech/jape"
+)
+
+func streamsSeq(jc jape.Context) {
+	jc.EncodeStream(func(yield func(int) bool) {
+		_ = yield(1) && yield(2)
+	})
+}
+
+func streamsChan(jc jape.Context) {
+	ch := make(chan string)
+	close(ch)
+	jc.EncodeStream(ch)
+}
+
+func streamsNotSeq(jc jape.Context) { // want `GET routes should write a response object`
+	jc.EncodeStream(42) // want `EncodeStream called on value that is neither an iter.Seq nor a channel`
+}
+
+func streamsPlain(jc jape.Context) {
+	jc.Encode(1)
+}
+
+func streamsEvents(jc jape.Context) {
+	es := jc.Events(time.Second)
+	defer es.Close()
+	es.Send("count", "", 1)
+}
+
+func streamsEventsMixed(jc jape.Context) {
+	es := jc.Events(0)
+	defer es.Close()
+	es.Send("a", "", 1)
+	es.Send("b", "", "two") // want `Send called on string, but was previously called on int`
+}
+
+func streamsWebSocket(jc jape.Context) {
+	ws, err := jape.UpgradeWebSocket[string, int](jc)
+	if err != nil {
+		return
+	}
+	defer ws.Close()
+}
+
+func streamsDownload(jc jape.Context) {
+	jc.WriteBody(strings.NewReader("foo"), 3)
+}
+
+func streamsUpload(jc jape.Context) {
+	jc.ReadBody(1<<20, func(r io.Reader) error {
+		_, err := io.Copy(io.Discard, r)
+		return err
+	})
+}
+
+func streamsSum(jc jape.Context) {
+	var n int
+	var sum int
+	if jc.DecodeStream(&n, func() error { sum += n; return nil }) != nil {
+		return
+	}
+	jc.Encode(sum)
+}
+
+func (c *client) streamsSeq() {
+	for range jape.Stream[int](context.Background(), &c.c, "/streams/seq") {
+	}
+}
+
+func (c *client) streamsSeqPlain() (v []int, err error) {
+	err = c.c.GET(context.Background(), "/streams/seq/plain", &v) // want `Client does not stream response from GET /streams/seq/plain, which calls EncodeStream`
+	return
+}
+
+func (c *client) streamsSeqWrong() {
+	for range jape.Stream[string](context.Background(), &c.c, "/streams/seq/wrong") { // want `Client has wrong response type for GET /streams/seq/wrong \(got string, should be int\)`
+	}
+}
+
+func (c *client) streamsChan() {
+	for range jape.Stream[string](context.Background(), &c.c, "/streams/chan") {
+	}
+}
+
+func (c *client) streamsPlain() {
+	for range jape.Stream[int](context.Background(), &c.c, "/streams/plain") { // want `Client streams response from GET /streams/plain, which does not call EncodeStream`
+	}
+}
+
+func (c *client) streamsEvents() {
+	for range jape.Subscribe[int](context.Background(), &c.c, "/streams/events") {
+	}
+}
+
+func (c *client) streamsEventsWrong() {
+	for range jape.Stream[int](context.Background(), &c.c, "/streams/events/wrong") { // want `Client expects GET /streams/events/wrong to call EncodeStream, but it calls Events`
+	}
+}
+
+func (c *client) streamsEventsMixed() {
+	for range jape.Subscribe[int](context.Background(), &c.c, "/streams/events/mixed") {
+	}
+}
+
+func (c *client) streamsWebSocket() {
+	// the client reads what the server writes, and vice versa
+	ws, err := jape.DialWebSocket[int, string](context.Background(), &c.c, "/streams/ws")
+	if err == nil {
+		ws.Close()
+	}
+}
+
+func (c *client) streamsDownload() error {
+	r, _, err := c.c.Download(context.Background(), "/streams/download")
+	if err == nil {
+		r.Close()
+	}
+	return err
+}
+
+func (c *client) streamsUpload() error {
+	return c.c.Upload(context.Background(), "PUT", "/streams/upload", strings.NewReader("foo"), 3, nil)
+}
+
+func (c *client) streamsSum() (n int, err error) {
+	err = jape.POSTStream(context.Background(), &c.c, "/streams/sum", slices.Values([]int{1, 2}), &n)
+	return
+}
+
+func (c *client) streamsSumPlain() (n int, err error) {
+	err = c.c.POST(context.Background(), "/streams/sum/plain", []int{1, 2}, &n) // want `Client does not stream request to POST /streams/sum/plain, which calls DecodeStream`
+	return
+}
@@ -0,0 +1,16 @@
+package strictheaders
+
+import (
+	"context"
+
+	"go.sia.tech/jape"
+)
+
+type client struct{ c jape.Client }
+
+func (c *client) version(key string) (v int, err error) {
+	var count int
+	var etag string
+	err = c.c.GET(context.Background(), "/version", &v, jape.WithHeader("X-Version", 1), jape.WithHeader(jape.IdempotencyKeyHeader, key), jape.ReadHeader("X-Count", &count), jape.ReadHeader("ETag", &etag)) // want `Client sends header "Idempotency-Key", which is not read by GET /version` `Client reads header "Etag", which is not set by GET /version`
+	return
+}
@@ -0,0 +1,16 @@
+package strictheaders
+
+import "go.sia.tech/jape"
+
+func routes() map[string]jape.Handler {
+	return map[string]jape.Handler{
+		"GET /version": func(jc jape.Context) {
+			var v int
+			if jc.DecodeHeader("X-Version", &v) != nil {
+				return
+			}
+			jc.SetHeader("X-Count", v)
+			jc.Encode(v)
+		},
+	}
+}
@@ -0,0 +1,195 @@
+package jape
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// IndexHeader is the response header containing the current index of a
+	// blocking query.
+	IndexHeader = "X-Index"
+
+	defaultWait = 5 * time.Minute
+	maxWait     = 10 * time.Minute
+)
+
+// An IndexNotifier tracks a monotonically increasing index, such as a version
+// number or modification count, and allows blocking queries to wait for it to
+// advance. The zero value is ready to use.
+type IndexNotifier struct {
+	mu    sync.Mutex
+	index uint64
+	ch    chan struct{} // closed when index advances
+}
+
+// Index returns the current index.
+func (n *IndexNotifier) Index() uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.index
+}
+
+func (n *IndexNotifier) notify() {
+	if n.ch != nil {
+		close(n.ch)
+		n.ch = nil
+	}
+}
+
+// Set sets the current index, waking any blocked queries. If index is not
+// greater than the current index, Set is a no-op.
+func (n *IndexNotifier) Set(index uint64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if index > n.index {
+		n.index = index
+		n.notify()
+	}
+}
+
+// Increment increments the current index, waking any blocked queries, and
+// returns the new index.
+func (n *IndexNotifier) Increment() uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.index++
+	n.notify()
+	return n.index
+}
+
+// Wait blocks until the current index is greater than index, or ctx is
+// canceled, and returns the current index.
+func (n *IndexNotifier) Wait(ctx context.Context, index uint64) (uint64, error) {
+	for {
+		n.mu.Lock()
+		if n.index > index {
+			defer n.mu.Unlock()
+			return n.index, nil
+		} else if n.ch == nil {
+			n.ch = make(chan struct{})
+		}
+		ch := n.ch
+		n.mu.Unlock()
+
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return n.Index(), ctx.Err()
+		}
+	}
+}
+
+// WaitIndex implements a blocking query. If the request contains an "index"
+// form value, WaitIndex blocks until n's index is greater than it, or until the
+// duration specified by the "wait" form value (e.g. "30s") elapses. The wait
+// defaults to 5 minutes, and is capped at 10 minutes. The current index is
+// written to the X-Index response header, so that the client can pass it in
+// its next request.
+//
+// If the form values are invalid, WaitIndex writes an error to the response
+// body and returns it. If the client disconnects while waiting, WaitIndex
+// returns an error without writing a response. HEAD requests never block.
+func (c Context) WaitIndex(n *IndexNotifier) error {
+	var index uint64
+	var wait string
+	if err := c.DecodeForm("index", &index); err != nil {
+		return err
+	} else if err := c.DecodeForm("wait", &wait); err != nil {
+		return err
+	}
+	d := defaultWait
+	if wait != "" {
+		var err error
+		if d, err = time.ParseDuration(wait); err != nil || d < 0 {
+			return c.Error(fmt.Errorf("invalid form value %q: %q", "wait", wait), http.StatusBadRequest)
+		}
+	}
+	d = min(d, maxWait)
+	d += rand.N(d/16 + 1) // add jitter to spread out simultaneous requests
+
+	current := n.Index()
+	if c.HasForm("index") && c.Request.Method != http.MethodHead {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), d)
+		defer cancel()
+		var err error
+		if current, err = n.Wait(ctx, index); err != nil && c.Request.Context().Err() != nil {
+			return err
+		}
+	}
+	c.SetHeader(IndexHeader, current)
+	return nil
+}
+
+// blockingWait returns the longest time that WaitIndex may block when the
+// client requests the specified wait, including jitter.
+func blockingWait(wait time.Duration) time.Duration {
+	d := defaultWait
+	if wait > 0 {
+		d = wait
+	}
+	d = min(d, maxWait)
+	return d + d/16
+}
+
+// Watch repeatedly performs blocking GET requests to a route that calls
+// Context.WaitIndex, yielding the response whenever the index advances. The
+// first response is yielded immediately. wait specifies how long each request
+// may block; if it is zero, the server's default is used. If a request fails,
+// the error is yielded, and Watch retries after a second. Iteration continues
+// until the caller stops it or ctx is canceled.
+func Watch[T any](ctx context.Context, c *Client, route string, wait time.Duration, opts ...RequestOption) iter.Seq2[T, error] {
+	sep := "?"
+	if strings.Contains(route, "?") {
+		sep = "&"
+	}
+	return func(yield func(T, error) bool) {
+		var index uint64
+		first := true
+		for {
+			r := route
+			timeout := c.Timeout
+			if !first {
+				r += fmt.Sprintf("%vindex=%d", sep, index)
+				if wait > 0 {
+					r += "&wait=" + wait.String()
+				}
+				if timeout > 0 {
+					timeout += blockingWait(wait)
+				}
+			}
+
+			var v T
+			var newIndex uint64
+			err := c.reqTimeout(ctx, timeout, http.MethodGet, r, nil, &v, append([]RequestOption{ReadHeader(IndexHeader, &newIndex)}, opts...)...)
+			if ctx.Err() != nil {
+				return
+			} else if err != nil {
+				if !yield(v, err) {
+					return
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(time.Second):
+				}
+				continue
+			}
+
+			// NOTE: the index may also go backwards, e.g. if the server
+			// restarted; this is treated as a change
+			changed := first || newIndex != index
+			first = false
+			index = newIndex
+			if changed && !yield(v, nil) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,460 @@
+package jape
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket opcodes, as defined by RFC 6455.
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// WebSocket close codes, as defined by RFC 6455.
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+)
+
+// A CloseError is returned by WebSocket.Read when the peer closes the
+// connection.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+// Error implements error.
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("websocket closed (%d)", e.Code)
+	}
+	return fmt.Sprintf("websocket closed (%d): %v", e.Code, e.Reason)
+}
+
+func websocketAccept(key string) string {
+	h := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// A wsConn implements RFC 6455 framing over a connection.
+type wsConn struct {
+	conn   io.ReadWriteCloser
+	br     *bufio.Reader
+	client bool // clients must mask their frames; servers must not
+
+	readLimit int64
+	keepAlive time.Duration
+	readTimer *time.Timer // used if conn does not support deadlines
+
+	wmu      sync.Mutex
+	closed   bool
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func newWSConn(conn io.ReadWriteCloser, br *bufio.Reader, client bool) *wsConn {
+	return &wsConn{
+		conn:      conn,
+		br:        br,
+		client:    client,
+		readLimit: 1e7, // 10 MB, same as Decode
+		stop:      make(chan struct{}),
+	}
+}
+
+func (ws *wsConn) writeFrame(opcode byte, payload []byte) error {
+	ws.wmu.Lock()
+	defer ws.wmu.Unlock()
+	if ws.closed {
+		return net.ErrClosed
+	}
+
+	hdr := make([]byte, 2, 14)
+	hdr[0] = 0x80 | opcode // FIN
+	switch n := len(payload); {
+	case n < 126:
+		hdr[1] = byte(n)
+	case n <= 0xFFFF:
+		hdr[1] = 126
+		hdr = binary.BigEndian.AppendUint16(hdr, uint16(n))
+	default:
+		hdr[1] = 127
+		hdr = binary.BigEndian.AppendUint64(hdr, uint64(n))
+	}
+	if ws.client {
+		hdr[1] |= 0x80
+		var key [4]byte
+		if _, err := rand.Read(key[:]); err != nil {
+			return err
+		}
+		hdr = append(hdr, key[:]...)
+		masked := make([]byte, len(payload))
+		for i := range payload {
+			masked[i] = payload[i] ^ key[i%4]
+		}
+		payload = masked
+	}
+	if _, err := ws.conn.Write(append(hdr, payload...)); err != nil {
+		return err
+	}
+	if opcode == opClose {
+		ws.closed = true
+	}
+	return nil
+}
+
+func (ws *wsConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(ws.br, hdr[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = hdr[0]&0x80 != 0
+	opcode = hdr[0] & 0x0F
+	if hdr[0]&0x70 != 0 {
+		return false, 0, nil, ws.fail(CloseProtocolError, "reserved bits set")
+	} else if masked := hdr[1]&0x80 != 0; masked == ws.client {
+		return false, 0, nil, ws.fail(CloseProtocolError, "invalid frame masking")
+	}
+	n := int64(hdr[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(ws.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(ws.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = int64(binary.BigEndian.Uint64(ext[:]) & (1<<63 - 1))
+	}
+	if opcode >= opClose && (n > 125 || !fin) {
+		return false, 0, nil, ws.fail(CloseProtocolError, "invalid control frame")
+	} else if n > ws.readLimit {
+		return false, 0, nil, ws.fail(CloseMessageTooBig, "message too large")
+	}
+	var key [4]byte
+	if !ws.client {
+		if _, err := io.ReadFull(ws.br, key[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(ws.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if !ws.client {
+		for i := range payload {
+			payload[i] ^= key[i%4]
+		}
+	}
+	return fin, opcode, payload, nil
+}
+
+// fail closes the connection with the specified code and returns a
+// corresponding error.
+func (ws *wsConn) fail(code int, reason string) error {
+	ws.close(code, reason)
+	return &CloseError{Code: code, Reason: reason}
+}
+
+// extendReadDeadline causes reads to fail if the next frame does not arrive
+// within d.
+func (ws *wsConn) extendReadDeadline(d time.Duration) {
+	if dc, ok := ws.conn.(interface{ SetReadDeadline(time.Time) error }); ok {
+		dc.SetReadDeadline(time.Now().Add(d))
+	} else if ws.readTimer == nil {
+		ws.readTimer = time.AfterFunc(d, func() { ws.conn.Close() })
+	} else {
+		ws.readTimer.Reset(d)
+	}
+}
+
+// readMessage returns the payload of the next text or binary message,
+// handling any control frames that precede it.
+func (ws *wsConn) readMessage() ([]byte, error) {
+	var msg []byte
+	var inMessage bool
+	for {
+		if ws.keepAlive > 0 {
+			ws.extendReadDeadline(2 * ws.keepAlive)
+		}
+		fin, opcode, payload, err := ws.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case opPing:
+			if err := ws.writeFrame(opPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			ce := &CloseError{Code: 1005} // no status received
+			if len(payload) >= 2 {
+				ce.Code = int(binary.BigEndian.Uint16(payload))
+				ce.Reason = string(payload[2:])
+			}
+			if ce.Code == 1005 {
+				ws.close(CloseNormal, "")
+			} else {
+				ws.close(ce.Code, "")
+			}
+			return nil, ce
+		case opText, opBinary:
+			if inMessage {
+				return nil, ws.fail(CloseProtocolError, "expected continuation frame")
+			}
+			inMessage = true
+		case opContinuation:
+			if !inMessage {
+				return nil, ws.fail(CloseProtocolError, "unexpected continuation frame")
+			}
+		default:
+			return nil, ws.fail(CloseProtocolError, fmt.Sprintf("unknown opcode %d", opcode))
+		}
+		if int64(len(msg)+len(payload)) > ws.readLimit {
+			return nil, ws.fail(CloseMessageTooBig, "message too large")
+		}
+		msg = append(msg, payload...)
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+// close sends a close frame, if one has not been sent already, and closes the
+// underlying connection.
+func (ws *wsConn) close(code int, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	payload = append(payload, reason...)
+	ws.writeFrame(opClose, payload)
+	ws.stopOnce.Do(func() { close(ws.stop) })
+	return ws.conn.Close()
+}
+
+func (ws *wsConn) startKeepAlive(interval time.Duration) {
+	ws.keepAlive = interval
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ws.stop:
+				return
+			case <-t.C:
+				if ws.writeFrame(opPing, nil) != nil {
+					return
+				}
+			}
+		}
+	}()
+}
+
+// A WebSocket is a WebSocket connection that reads messages of type R and
+// writes messages of type W, encoded as JSON. Read and Write may be called
+// concurrently with each other, but concurrent calls to Read are not
+// permitted.
+type WebSocket[R, W any] struct {
+	ws *wsConn
+}
+
+// Read reads the next message from the connection. If the peer closed the
+// connection, the returned error is a *CloseError.
+func (ws *WebSocket[R, W]) Read() (R, error) {
+	var v R
+	msg, err := ws.ws.readMessage()
+	if err != nil {
+		return v, err
+	} else if err := json.Unmarshal(msg, &v); err != nil {
+		return v, fmt.Errorf("couldn't decode message type (%T): %w", v, err)
+	}
+	return v, nil
+}
+
+// Write writes a message to the connection.
+func (ws *WebSocket[R, W]) Write(v W) error {
+	js, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("couldn't encode message type (%T): %w", v, err)
+	}
+	return ws.ws.writeFrame(opText, js)
+}
+
+// SetReadLimit sets the maximum size of a message read from the connection.
+// The default limit is 10 MB. If a larger message is received, the connection
+// is closed.
+func (ws *WebSocket[R, W]) SetReadLimit(n int64) {
+	ws.ws.readLimit = n
+}
+
+// KeepAlive causes a ping to be sent at the specified interval. If no frames
+// are received from the peer for two intervals, Read fails. Note that pings
+// are only answered while the peer is blocked in Read. KeepAlive must be called
+// at most once, before Read.
+func (ws *WebSocket[R, W]) KeepAlive(interval time.Duration) {
+	ws.ws.startKeepAlive(interval)
+}
+
+// Close closes the connection normally.
+func (ws *WebSocket[R, W]) Close() error {
+	return ws.ws.close(CloseNormal, "")
+}
+
+// CloseWithReason closes the connection with the specified close code and
+// reason.
+func (ws *WebSocket[R, W]) CloseWithReason(code int, reason string) error {
+	return ws.ws.close(code, reason)
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// upgradeWebSocket performs the server side of the WebSocket handshake. If the
+// request is not a valid WebSocket handshake, it writes an error to the
+// response body and returns it.
+func (c Context) upgradeWebSocket() (*wsConn, error) {
+	h := c.Request.Header
+	key := h.Get("Sec-WebSocket-Key")
+	if c.Request.Method != http.MethodGet || !headerContains(h, "Connection", "upgrade") || !headerContains(h, "Upgrade", "websocket") || key == "" {
+		return nil, c.Error(errors.New("not a websocket handshake"), http.StatusBadRequest)
+	} else if h.Get("Sec-WebSocket-Version") != "13" {
+		c.ResponseWriter.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, c.Error(errors.New("unsupported websocket version"), http.StatusUpgradeRequired)
+	}
+	conn, rw, err := http.NewResponseController(c.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, c.Error(fmt.Errorf("couldn't hijack connection: %w", err), http.StatusInternalServerError)
+	}
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + websocketAccept(key) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return newWSConn(conn, rw.Reader, false), nil
+}
+
+// UpgradeWebSocket upgrades the connection to a WebSocket that reads messages
+// of type R from the client and writes messages of type W. If the request is
+// not a valid WebSocket handshake, UpgradeWebSocket writes an error to the
+// response body and returns it. Once upgraded, the handler must not use c to
+// write a response, and must close the WebSocket before returning.
+func UpgradeWebSocket[R, W any](c Context) (*WebSocket[R, W], error) {
+	ws, err := c.upgradeWebSocket()
+	if err != nil {
+		return nil, err
+	}
+	return &WebSocket[R, W]{ws: ws}, nil
+}
+
+// dialWebSocket performs the client side of the WebSocket handshake.
+func (c *Client) dialWebSocket(ctx context.Context, route string, opts ...RequestOption) (*wsConn, error) {
+	u, err := url.Parse(c.BaseURL + route)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	}
+	var nonce [16]byte
+	rand.Read(nonce[:])
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		panic(err)
+	}
+	// net/http uses HTTP/1.1 for WebSocket upgrades, and returns the
+	// upgraded connection as the response body
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if c.Password != "" {
+		req.SetBasicAuth("", c.Password)
+	}
+	path, _, _ := strings.Cut(route, "?")
+	ro := requestOptions{req: req, info: RequestInfo{Method: http.MethodGet, Route: path}}
+	for _, opt := range opts {
+		opt(&ro)
+	}
+	r, err := c.send(req, ro.info)
+	if err != nil {
+		return nil, err
+	}
+	for _, fn := range ro.onReceive {
+		fn(r)
+	}
+	if r.StatusCode != http.StatusSwitchingProtocols {
+		return nil, c.responseError(r)
+	}
+	conn, ok := r.Body.(io.ReadWriteCloser)
+	if !ok {
+		r.Body.Close()
+		return nil, errors.New("transport does not support protocol upgrades")
+	} else if r.Header.Get("Sec-WebSocket-Accept") != websocketAccept(key) {
+		conn.Close()
+		return nil, errors.New("invalid Sec-WebSocket-Accept header")
+	}
+	for _, fn := range ro.onResponse {
+		if err := fn(r); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return newWSConn(conn, bufio.NewReader(conn), true), nil
+}
+
+// DialWebSocket connects to a route that calls UpgradeWebSocket, returning a
+// WebSocket that reads messages of type R from the server and writes messages
+// of type W.
+func DialWebSocket[R, W any](ctx context.Context, c *Client, route string, opts ...RequestOption) (*WebSocket[R, W], error) {
+	ws, err := c.dialWebSocket(ctx, route, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &WebSocket[R, W]{ws: ws}, nil
+}